@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CheckOrigin decides whether to accept an upgrade request's Origin. The
+// default rejects cross-origin browser requests but allows non-browser
+// clients that don't send an Origin header at all.
+var CheckOrigin = sameHostOrigin
+
+// Authenticate gates every upgrade request before it's hijacked. The
+// default accepts everyone; set it to reject based on a bearer token
+// extracted with bearerToken.
+var Authenticate Authenticator = noAuth{}
+
+// MaxConnsPerIP caps concurrent connections from a single remote IP; 0
+// means unlimited.
+var MaxConnsPerIP = 0
+
+// MaxConns caps concurrent connections server-wide; 0 means unlimited.
+var MaxConns = 0
+
+// Authenticator decides whether an upgrade request may proceed.
+type Authenticator interface {
+	// Authenticate inspects r and reports whether the upgrade may
+	// proceed. When ok is false, status is the HTTP status the caller
+	// should respond with (401 or 403).
+	Authenticate(r *http.Request) (ok bool, status int)
+}
+
+// AuthenticatorFunc adapts a plain function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (ok bool, status int)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (bool, int) { return f(r) }
+
+// noAuth is the default Authenticator: it allows every upgrade request.
+type noAuth struct{}
+
+func (noAuth) Authenticate(r *http.Request) (bool, int) { return true, 0 }
+
+// bearerToken extracts a bearer token from the Authorization header, the
+// Sec-WebSocket-Protocol offer list (as "bearer.<token>", since browsers
+// can't set arbitrary headers during the WebSocket handshake), or an
+// access_token query parameter, in that order. An Authenticator can call
+// this to implement token-based auth.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	for _, offered := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		offered = strings.TrimSpace(offered)
+		if token, ok := strings.CutPrefix(offered, "bearer."); ok {
+			return token
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// sameHostOrigin allows requests with no Origin header (non-browser
+// clients) and requests whose Origin host matches r.Host.
+func sameHostOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// connLimiter enforces MaxConnsPerIP and MaxConns.
+type connLimiter struct {
+	mu    sync.Mutex
+	perIP map[string]int
+	total int
+}
+
+var connLimit = &connLimiter{}
+
+// tryAcquire reports whether ip may open another connection under the
+// given per-IP and total caps (0 meaning unlimited), reserving a slot if
+// so.
+func (l *connLimiter) tryAcquire(ip string, maxPerIP, maxTotal int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if maxTotal > 0 && l.total >= maxTotal {
+		return false
+	}
+	if maxPerIP > 0 && l.perIP[ip] >= maxPerIP {
+		return false
+	}
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	l.perIP[ip]++
+	l.total++
+	return true
+}
+
+// release frees the slot acquired for ip.
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+	l.total--
+}
+
+// remoteIP extracts the host portion of r.RemoteAddr, falling back to
+// the raw value if it isn't in host:port form.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
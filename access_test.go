@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSameHostOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin header", host: "example.com", origin: "", want: true},
+		{name: "matching origin", host: "example.com", origin: "https://example.com", want: true},
+		{name: "cross origin", host: "example.com", origin: "https://evil.com", want: false},
+		{name: "malformed origin", host: "example.com", origin: "://not-a-url", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{Host: tt.host, Header: http.Header{}}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := sameHostOrigin(r); got != tt.want {
+				t.Errorf("sameHostOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		query  string
+		want   string
+	}{
+		{
+			name:   "authorization header",
+			header: http.Header{"Authorization": {"Bearer abc123"}},
+			want:   "abc123",
+		},
+		{
+			name:   "sec-websocket-protocol list",
+			header: http.Header{"Sec-WebSocket-Protocol": {"chat.v1, bearer.xyz789"}},
+			want:   "xyz789",
+		},
+		{
+			name:  "query parameter",
+			query: "access_token=qwerty",
+			want:  "qwerty",
+		},
+		{
+			name: "no token offered",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, values := range tt.header {
+				header.Set(name, values[0])
+			}
+			r := &http.Request{Header: header, URL: &url.URL{RawQuery: tt.query}}
+			if got := bearerToken(r); got != tt.want {
+				t.Errorf("bearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnLimiter_TryAcquireAndRelease(t *testing.T) {
+	l := &connLimiter{}
+
+	if !l.tryAcquire("1.1.1.1", 1, 0) {
+		t.Fatalf("tryAcquire() = false, want true for the first connection from an IP")
+	}
+	if l.tryAcquire("1.1.1.1", 1, 0) {
+		t.Fatalf("tryAcquire() = true, want false once maxPerIP is reached")
+	}
+	if !l.tryAcquire("2.2.2.2", 1, 0) {
+		t.Fatalf("tryAcquire() = false, want true for a different IP")
+	}
+
+	l.release("1.1.1.1")
+	if !l.tryAcquire("1.1.1.1", 1, 0) {
+		t.Fatalf("tryAcquire() = false, want true after releasing the slot")
+	}
+}
+
+func TestConnLimiter_GlobalCap(t *testing.T) {
+	l := &connLimiter{}
+
+	if !l.tryAcquire("1.1.1.1", 0, 1) {
+		t.Fatalf("tryAcquire() = false, want true for the first connection under the global cap")
+	}
+	if l.tryAcquire("2.2.2.2", 0, 1) {
+		t.Fatalf("tryAcquire() = true, want false once the global cap is reached, even for a new IP")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+)
+
+// negotiatedExtension is the result of matching a client's
+// Sec-WebSocket-Extensions offer against the permessage-deflate
+// parameters this server supports.
+type negotiatedExtension struct {
+	enabled                 bool
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+}
+
+// negotiatePermessageDeflate parses the Sec-WebSocket-Extensions header
+// and, if the client offered permessage-deflate and compression is
+// enabled server-wide, picks the parameters to accept. Window-bits
+// parameters are accepted but not enforced, since compress/flate always
+// uses a 32K window.
+func negotiatePermessageDeflate(header string) negotiatedExtension {
+	if !EnableCompression || header == "" {
+		return negotiatedExtension{}
+	}
+
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		ext := negotiatedExtension{enabled: true}
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.TrimSpace(name) {
+			case "client_no_context_takeover":
+				ext.clientNoContextTakeover = true
+			case "server_no_context_takeover":
+				ext.serverNoContextTakeover = true
+			case "client_max_window_bits", "server_max_window_bits":
+				// Accepted but ignored; see doc comment above.
+			}
+		}
+		return ext
+	}
+
+	return negotiatedExtension{}
+}
+
+// responseHeader builds the Sec-WebSocket-Extensions value to echo back
+// once permessage-deflate has been accepted.
+func (ext negotiatedExtension) responseHeader() string {
+	var b strings.Builder
+	b.WriteString("permessage-deflate")
+	if ext.serverNoContextTakeover {
+		b.WriteString("; server_no_context_takeover")
+	}
+	if ext.clientNoContextTakeover {
+		b.WriteString("; client_no_context_takeover")
+	}
+	return b.String()
+}
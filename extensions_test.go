@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestNegotiatePermessageDeflate(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		header  string
+		want    negotiatedExtension
+	}{
+		{
+			name:    "no offer",
+			enabled: true,
+			header:  "",
+			want:    negotiatedExtension{},
+		},
+		{
+			name:    "compression disabled server-side",
+			enabled: false,
+			header:  "permessage-deflate",
+			want:    negotiatedExtension{},
+		},
+		{
+			name:    "plain offer",
+			enabled: true,
+			header:  "permessage-deflate",
+			want:    negotiatedExtension{enabled: true},
+		},
+		{
+			name:    "offer with context takeover params",
+			enabled: true,
+			header:  "permessage-deflate; client_no_context_takeover; server_no_context_takeover",
+			want: negotiatedExtension{
+				enabled:                 true,
+				clientNoContextTakeover: true,
+				serverNoContextTakeover: true,
+			},
+		},
+		{
+			name:    "unrelated extension offered",
+			enabled: true,
+			header:  "x-webkit-deflate-frame",
+			want:    negotiatedExtension{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			EnableCompression = tt.enabled
+			defer func() { EnableCompression = true }()
+
+			got := negotiatePermessageDeflate(tt.header)
+			if got != tt.want {
+				t.Errorf("negotiatePermessageDeflate(%q) = %+v, want %+v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiatedExtension_ResponseHeader(t *testing.T) {
+	ext := negotiatedExtension{enabled: true, clientNoContextTakeover: true, serverNoContextTakeover: true}
+	want := "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	if got := ext.responseHeader(); got != want {
+		t.Errorf("responseHeader() = %q, want %q", got, want)
+	}
+}
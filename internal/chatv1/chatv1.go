@@ -0,0 +1,71 @@
+// Package chatv1 implements "chat.v1", the server's original behavior:
+// the first text message a connection sends becomes its username, and
+// every message after that is broadcast to the room prefixed with it.
+package chatv1
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+// Name is the Sec-WebSocket-Protocol token this package negotiates.
+const Name = "chat.v1"
+
+// Protocol is a per-connection chat.v1 session.
+type Protocol struct {
+	username    string
+	usernameSet bool
+}
+
+// New creates a Protocol instance for one connection.
+func New() *Protocol {
+	return &Protocol{}
+}
+
+func (p *Protocol) Name() string { return Name }
+
+func (p *Protocol) OnOpen(conn subprotocol.Conn) {
+	preset, ok := conn.(subprotocol.PresetUsername)
+	if !ok {
+		return
+	}
+	username, ok := preset.PresetUsername()
+	if !ok {
+		return
+	}
+
+	p.username = username
+	p.usernameSet = true
+	log.Printf("Username set for connection: %s\n", p.username)
+	conn.Broadcast(wsproto.OpcodeText, []byte(fmt.Sprintf("[Server]: %s joined the chat", p.username)))
+}
+
+func (p *Protocol) OnMessage(conn subprotocol.Conn, opcode byte, payload []byte) {
+	if opcode != wsproto.OpcodeText {
+		return
+	}
+
+	message := string(payload)
+	if !p.usernameSet {
+		p.username = message
+		p.usernameSet = true
+		conn.SetUsername(p.username)
+		log.Printf("Username set for connection: %s\n", p.username)
+		conn.Broadcast(wsproto.OpcodeText, []byte(fmt.Sprintf("[Server]: %s joined the chat", p.username)))
+		return
+	}
+
+	log.Printf("[%s]: %s\n", p.username, message)
+	conn.Broadcast(wsproto.OpcodeText, []byte(fmt.Sprintf("[%s]: %s", p.username, message)))
+}
+
+func (p *Protocol) OnClose(conn subprotocol.Conn) {
+	if !p.usernameSet {
+		return
+	}
+	log.Printf("%s disconnected", p.username)
+	conn.Broadcast(wsproto.OpcodeText, []byte(fmt.Sprintf("[Server]: %s left the chat", p.username)))
+}
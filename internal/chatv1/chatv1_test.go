@@ -0,0 +1,120 @@
+package chatv1
+
+import (
+	"testing"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+type fakeConn struct {
+	username   string
+	sent       [][]byte
+	broadcasts [][]byte
+
+	presetUsername string
+	hasPreset      bool
+}
+
+func (f *fakeConn) PresetUsername() (string, bool) {
+	return f.presetUsername, f.hasPreset
+}
+
+func (f *fakeConn) Send(opcode byte, payload []byte) error {
+	f.sent = append(f.sent, payload)
+	return nil
+}
+
+func (f *fakeConn) Broadcast(opcode byte, payload []byte) {
+	f.broadcasts = append(f.broadcasts, payload)
+}
+
+func (f *fakeConn) SetUsername(name string) {
+	f.username = name
+}
+
+func TestProtocol_FirstMessageSetsUsername(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("alice"))
+
+	if conn.username != "alice" {
+		t.Errorf("username = %q, want %q", conn.username, "alice")
+	}
+	if len(conn.broadcasts) != 1 || string(conn.broadcasts[0]) != "[Server]: alice joined the chat" {
+		t.Errorf("broadcasts = %q, want join announcement", conn.broadcasts)
+	}
+}
+
+func TestProtocol_OnOpen_UsesPresetUsernameAndSkipsBootstrap(t *testing.T) {
+	p := New()
+	conn := &fakeConn{presetUsername: "alice", hasPreset: true}
+
+	p.OnOpen(conn)
+
+	if len(conn.broadcasts) != 1 || string(conn.broadcasts[0]) != "[Server]: alice joined the chat" {
+		t.Fatalf("broadcasts = %q, want join announcement for alice", conn.broadcasts)
+	}
+
+	// The first text frame must not overwrite the preset identity.
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("hello room"))
+
+	want := "[alice]: hello room"
+	if len(conn.broadcasts) != 2 || string(conn.broadcasts[1]) != want {
+		t.Errorf("broadcasts = %q, want second entry %q", conn.broadcasts, want)
+	}
+}
+
+func TestProtocol_OnOpen_NoopWithoutPresetUsername(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnOpen(conn)
+
+	if len(conn.broadcasts) != 0 {
+		t.Errorf("broadcasts = %q, want none without a preset identity", conn.broadcasts)
+	}
+}
+
+func TestProtocol_SubsequentMessagesBroadcastWithPrefix(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("alice"))
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("hello room"))
+
+	want := "[alice]: hello room"
+	if len(conn.broadcasts) != 2 || string(conn.broadcasts[1]) != want {
+		t.Errorf("broadcasts = %q, want second entry %q", conn.broadcasts, want)
+	}
+}
+
+func TestProtocol_IgnoresBinaryMessages(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeBinary, []byte{0x01, 0x02})
+
+	if len(conn.broadcasts) != 0 {
+		t.Errorf("broadcasts = %q, want none for a binary message", conn.broadcasts)
+	}
+}
+
+func TestProtocol_OnCloseAnnouncesLeaveOnlyAfterUsernameSet(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnClose(conn)
+	if len(conn.broadcasts) != 0 {
+		t.Errorf("broadcasts = %q, want none before a username was set", conn.broadcasts)
+	}
+
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("bob"))
+	conn.broadcasts = nil
+
+	p.OnClose(conn)
+	want := "[Server]: bob left the chat"
+	if len(conn.broadcasts) != 1 || string(conn.broadcasts[0]) != want {
+		t.Errorf("broadcasts = %q, want %q", conn.broadcasts, want)
+	}
+}
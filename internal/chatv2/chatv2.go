@@ -0,0 +1,227 @@
+// Package chatv2 implements "chat.v2": a JSON-envelope chat protocol with
+// rooms and presence, replacing chat.v1's raw-text, single-room bootstrap.
+// A client authenticates with a hello message, then joins one or more
+// rooms; every other operation is scoped to a room.
+package chatv2
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/hub"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+// Name is the Sec-WebSocket-Protocol token this package negotiates.
+const Name = "chat.v2"
+
+// messageType enumerates the envelope "type" values this protocol
+// understands.
+type messageType string
+
+const (
+	typeHello    messageType = "hello"
+	typeJoin     messageType = "join"
+	typeLeave    messageType = "leave"
+	typeMsg      messageType = "msg"
+	typeKick     messageType = "kick"
+	typeList     messageType = "list"
+	typePresence messageType = "presence"
+	typeError    messageType = "error"
+)
+
+// envelope is the wire format for every chat.v2 message: a flat JSON
+// object whose Type selects which of the other fields are meaningful.
+type envelope struct {
+	Type     messageType `json:"type"`
+	Room     string      `json:"room,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Token    string      `json:"token,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Members  []string    `json:"members,omitempty"`
+	Reason   string      `json:"reason,omitempty"`
+}
+
+// Authenticator validates the credentials carried by a hello message.
+type Authenticator func(username, token string) bool
+
+// Protocol is a per-connection chat.v2 session backed by a shared Hub.
+type Protocol struct {
+	hub           *hub.Hub
+	authenticate  Authenticator
+	username      string
+	authenticated bool
+	rooms         map[string]bool
+}
+
+// New creates a Protocol instance for one connection, backed by the
+// shared h. auth may be nil to accept any hello message's credentials.
+func New(h *hub.Hub, auth Authenticator) *Protocol {
+	return &Protocol{hub: h, authenticate: auth, rooms: make(map[string]bool)}
+}
+
+func (p *Protocol) Name() string { return Name }
+
+func (p *Protocol) OnOpen(conn subprotocol.Conn) {}
+
+func (p *Protocol) OnMessage(conn subprotocol.Conn, opcode byte, payload []byte) {
+	if opcode != wsproto.OpcodeText {
+		return
+	}
+
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		p.sendError(conn, "", "malformed JSON envelope")
+		return
+	}
+
+	switch env.Type {
+	case typeHello:
+		p.handleHello(conn, env)
+	case typeJoin:
+		p.handleJoin(conn, env)
+	case typeLeave:
+		p.handleLeave(conn, env)
+	case typeMsg:
+		p.handleMsg(conn, env)
+	case typeKick:
+		p.handleKick(conn, env)
+	case typeList:
+		p.handleList(conn, env)
+	default:
+		p.sendError(conn, env.Room, fmt.Sprintf("unknown message type %q", env.Type))
+	}
+}
+
+func (p *Protocol) OnClose(conn subprotocol.Conn) {
+	if !p.authenticated {
+		return
+	}
+	for _, room := range p.hub.LeaveAll(p.member(conn)) {
+		p.announcePresence(room, "left")
+	}
+}
+
+func (p *Protocol) handleHello(conn subprotocol.Conn, env envelope) {
+	if env.Username == "" {
+		p.sendError(conn, "", "hello requires a username")
+		return
+	}
+	if p.authenticate != nil && !p.authenticate(env.Username, env.Token) {
+		p.sendError(conn, "", "authentication failed")
+		return
+	}
+
+	p.username = env.Username
+	p.authenticated = true
+	conn.SetUsername(p.username)
+	log.Printf("chat.v2: %s authenticated\n", p.username)
+}
+
+func (p *Protocol) handleJoin(conn subprotocol.Conn, env envelope) {
+	if !p.requireAuth(conn) {
+		return
+	}
+	if env.Room == "" {
+		p.sendError(conn, "", "join requires a room")
+		return
+	}
+
+	p.hub.Join(env.Room, p.member(conn))
+	p.rooms[env.Room] = true
+	log.Printf("chat.v2: %s joined %s\n", p.username, env.Room)
+	p.announcePresence(env.Room, "joined")
+}
+
+func (p *Protocol) handleLeave(conn subprotocol.Conn, env envelope) {
+	if !p.requireAuth(conn) || env.Room == "" {
+		return
+	}
+
+	p.hub.Leave(env.Room, p.member(conn))
+	delete(p.rooms, env.Room)
+	log.Printf("chat.v2: %s left %s\n", p.username, env.Room)
+	p.announcePresence(env.Room, "left")
+}
+
+func (p *Protocol) handleMsg(conn subprotocol.Conn, env envelope) {
+	if !p.requireAuth(conn) || env.Room == "" {
+		return
+	}
+	if !p.rooms[env.Room] {
+		p.sendError(conn, env.Room, "not a member of this room")
+		return
+	}
+
+	out, err := json.Marshal(envelope{Type: typeMsg, Room: env.Room, Username: p.username, Body: env.Body})
+	if err != nil {
+		return
+	}
+	p.hub.Broadcast(env.Room, out)
+}
+
+func (p *Protocol) handleKick(conn subprotocol.Conn, env envelope) {
+	if !p.requireAuth(conn) || env.Room == "" || env.Username == "" {
+		return
+	}
+	if p.hub.Kick(env.Room, env.Username) {
+		log.Printf("chat.v2: %s kicked %s from %s\n", p.username, env.Username, env.Room)
+		p.announcePresence(env.Room, "kicked")
+	}
+}
+
+func (p *Protocol) handleList(conn subprotocol.Conn, env envelope) {
+	if !p.requireAuth(conn) || env.Room == "" {
+		return
+	}
+
+	out, err := json.Marshal(envelope{Type: typeList, Room: env.Room, Members: p.hub.List(env.Room)})
+	if err != nil {
+		return
+	}
+	conn.Send(wsproto.OpcodeText, out)
+}
+
+func (p *Protocol) requireAuth(conn subprotocol.Conn) bool {
+	if !p.authenticated {
+		p.sendError(conn, "", "send a hello message first")
+		return false
+	}
+	return true
+}
+
+func (p *Protocol) announcePresence(room, reason string) {
+	out, err := json.Marshal(envelope{Type: typePresence, Room: room, Username: p.username, Reason: reason})
+	if err != nil {
+		return
+	}
+	p.hub.Broadcast(room, out)
+}
+
+func (p *Protocol) sendError(conn subprotocol.Conn, room, reason string) {
+	out, err := json.Marshal(envelope{Type: typeError, Room: room, Reason: reason})
+	if err != nil {
+		return
+	}
+	conn.Send(wsproto.OpcodeText, out)
+}
+
+// member adapts this Protocol's connection to hub.Member, keyed by
+// username - usernames are assumed unique for the lifetime of a room,
+// mirroring chat.v1's original unenforced assumption.
+func (p *Protocol) member(conn subprotocol.Conn) hub.Member {
+	return connMember{conn: conn, username: p.username}
+}
+
+type connMember struct {
+	conn     subprotocol.Conn
+	username string
+}
+
+func (m connMember) ID() string       { return m.username }
+func (m connMember) Username() string { return m.username }
+func (m connMember) Send(payload []byte) error {
+	return m.conn.Send(wsproto.OpcodeText, payload)
+}
@@ -0,0 +1,137 @@
+package chatv2
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/hub"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+type fakeConn struct {
+	username string
+	sent     [][]byte
+}
+
+func (f *fakeConn) Send(opcode byte, payload []byte) error {
+	f.sent = append(f.sent, payload)
+	return nil
+}
+
+func (f *fakeConn) Broadcast(opcode byte, payload []byte) {}
+
+func (f *fakeConn) SetUsername(name string) {
+	f.username = name
+}
+
+func sendEnvelope(p *Protocol, conn *fakeConn, env envelope) {
+	payload, _ := json.Marshal(env)
+	p.OnMessage(conn, wsproto.OpcodeText, payload)
+}
+
+func lastSent(conn *fakeConn) envelope {
+	var env envelope
+	json.Unmarshal(conn.sent[len(conn.sent)-1], &env)
+	return env
+}
+
+func TestProtocol_RequiresHelloBeforeJoin(t *testing.T) {
+	p := New(hub.New(), nil)
+	conn := &fakeConn{}
+
+	sendEnvelope(p, conn, envelope{Type: typeJoin, Room: "general"})
+
+	if len(conn.sent) != 1 || lastSent(conn).Type != typeError {
+		t.Fatalf("sent = %v, want a single error envelope", conn.sent)
+	}
+}
+
+func TestProtocol_HelloRejectedByAuthenticator(t *testing.T) {
+	auth := func(username, token string) bool { return token == "correct" }
+	p := New(hub.New(), auth)
+	conn := &fakeConn{}
+
+	sendEnvelope(p, conn, envelope{Type: typeHello, Username: "alice", Token: "wrong"})
+
+	if conn.username != "" {
+		t.Errorf("username = %q, want unset after failed authentication", conn.username)
+	}
+	if len(conn.sent) != 1 || lastSent(conn).Type != typeError {
+		t.Fatalf("sent = %v, want a single error envelope", conn.sent)
+	}
+}
+
+func TestProtocol_JoinAnnouncesPresenceAndMsgBroadcastsToRoom(t *testing.T) {
+	h := hub.New()
+	p1 := New(h, nil)
+	p2 := New(h, nil)
+	alice := &fakeConn{}
+	bob := &fakeConn{}
+
+	sendEnvelope(p1, alice, envelope{Type: typeHello, Username: "alice"})
+	sendEnvelope(p1, alice, envelope{Type: typeJoin, Room: "general"})
+	sendEnvelope(p2, bob, envelope{Type: typeHello, Username: "bob"})
+	sendEnvelope(p2, bob, envelope{Type: typeJoin, Room: "general"})
+
+	if got := lastSent(alice); got.Type != typePresence || got.Username != "bob" || got.Reason != "joined" {
+		t.Errorf("alice received %+v, want presence for bob joining", got)
+	}
+
+	sendEnvelope(p1, alice, envelope{Type: typeMsg, Room: "general", Body: "hi"})
+
+	got := lastSent(bob)
+	if got.Type != typeMsg || got.Username != "alice" || got.Body != "hi" {
+		t.Errorf("bob received %+v, want msg from alice saying hi", got)
+	}
+}
+
+func TestProtocol_MsgRejectedWithoutJoin(t *testing.T) {
+	p := New(hub.New(), nil)
+	conn := &fakeConn{}
+
+	sendEnvelope(p, conn, envelope{Type: typeHello, Username: "alice"})
+	sendEnvelope(p, conn, envelope{Type: typeMsg, Room: "general", Body: "hi"})
+
+	if lastSent(conn).Type != typeError {
+		t.Fatalf("sent = %v, want an error envelope for an unjoined room", conn.sent)
+	}
+}
+
+func TestProtocol_ListReturnsRoomMembers(t *testing.T) {
+	h := hub.New()
+	p1 := New(h, nil)
+	p2 := New(h, nil)
+	alice := &fakeConn{}
+	bob := &fakeConn{}
+
+	sendEnvelope(p1, alice, envelope{Type: typeHello, Username: "alice"})
+	sendEnvelope(p1, alice, envelope{Type: typeJoin, Room: "general"})
+	sendEnvelope(p2, bob, envelope{Type: typeHello, Username: "bob"})
+	sendEnvelope(p2, bob, envelope{Type: typeJoin, Room: "general"})
+
+	sendEnvelope(p1, alice, envelope{Type: typeList, Room: "general"})
+
+	got := lastSent(alice)
+	if got.Type != typeList || len(got.Members) != 2 {
+		t.Errorf("list = %+v, want both alice and bob", got)
+	}
+}
+
+func TestProtocol_OnCloseLeavesEveryJoinedRoom(t *testing.T) {
+	h := hub.New()
+	p := New(h, nil)
+	conn := &fakeConn{}
+
+	sendEnvelope(p, conn, envelope{Type: typeHello, Username: "alice"})
+	sendEnvelope(p, conn, envelope{Type: typeJoin, Room: "general"})
+	sendEnvelope(p, conn, envelope{Type: typeJoin, Room: "random"})
+
+	p.OnClose(conn)
+
+	if got := h.List("general"); len(got) != 0 {
+		t.Errorf("List(general) after OnClose = %v, want empty", got)
+	}
+	if got := h.List("random"); len(got) != 0 {
+		t.Errorf("List(random) after OnClose = %v, want empty", got)
+	}
+}
@@ -0,0 +1,121 @@
+// Package hub tracks chat room membership and fans messages out to the
+// members of a room. It holds no transport state of its own; delivery and
+// backpressure are each Member implementation's responsibility.
+package hub
+
+import "sync"
+
+// Member is a hub participant: something identifiable that can receive an
+// already-encoded message.
+type Member interface {
+	ID() string
+	Username() string
+	Send(payload []byte) error
+}
+
+// Hub tracks which members belong to which rooms.
+type Hub struct {
+	mu    sync.Mutex
+	rooms map[string]map[string]Member
+}
+
+// New creates an empty Hub.
+func New() *Hub {
+	return &Hub{rooms: make(map[string]map[string]Member)}
+}
+
+// Join adds m to room, creating the room if it doesn't exist yet.
+func (h *Hub) Join(room string, m Member) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[string]Member)
+		h.rooms[room] = members
+	}
+	members[m.ID()] = m
+}
+
+// Leave removes m from room, dropping the room entirely once it's empty.
+func (h *Hub) Leave(room string, m Member) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaveLocked(room, m.ID())
+}
+
+func (h *Hub) leaveLocked(room, memberID string) {
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, memberID)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// LeaveAll removes m from every room it belongs to, returning the names
+// of the rooms it was in.
+func (h *Hub) LeaveAll(m Member) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var left []string
+	for room, members := range h.rooms {
+		if _, ok := members[m.ID()]; ok {
+			left = append(left, room)
+		}
+	}
+	for _, room := range left {
+		h.leaveLocked(room, m.ID())
+	}
+	return left
+}
+
+// Kick removes memberID from room, reporting whether it was present.
+func (h *Hub) Kick(room, memberID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	members, ok := h.rooms[room]
+	if !ok {
+		return false
+	}
+	if _, present := members[memberID]; !present {
+		return false
+	}
+	h.leaveLocked(room, memberID)
+	return true
+}
+
+// List returns the usernames of every member currently in room.
+func (h *Hub) List(room string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	members := h.rooms[room]
+	names := make([]string, 0, len(members))
+	for _, m := range members {
+		names = append(names, m.Username())
+	}
+	return names
+}
+
+// Broadcast delivers payload to every member of room. A member whose Send
+// fails is removed from the room; the caller's transport is responsible
+// for tearing down its connection.
+func (h *Hub) Broadcast(room string, payload []byte) {
+	h.mu.Lock()
+	members := make([]Member, 0, len(h.rooms[room]))
+	for _, m := range h.rooms[room] {
+		members = append(members, m)
+	}
+	h.mu.Unlock()
+
+	for _, m := range members {
+		if err := m.Send(payload); err != nil {
+			h.Leave(room, m)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package hub
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type fakeMember struct {
+	id   string
+	fail bool
+	got  [][]byte
+}
+
+func (m *fakeMember) ID() string       { return m.id }
+func (m *fakeMember) Username() string { return m.id }
+func (m *fakeMember) Send(payload []byte) error {
+	if m.fail {
+		return errors.New("send failed")
+	}
+	m.got = append(m.got, payload)
+	return nil
+}
+
+func TestHub_JoinAndList(t *testing.T) {
+	h := New()
+	alice := &fakeMember{id: "alice"}
+	bob := &fakeMember{id: "bob"}
+
+	h.Join("general", alice)
+	h.Join("general", bob)
+
+	got := h.List("general")
+	sort.Strings(got)
+	want := []string{"alice", "bob"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestHub_LeaveRemovesMemberAndEmptiesRoom(t *testing.T) {
+	h := New()
+	alice := &fakeMember{id: "alice"}
+
+	h.Join("general", alice)
+	h.Leave("general", alice)
+
+	if got := h.List("general"); len(got) != 0 {
+		t.Errorf("List() after Leave = %v, want empty", got)
+	}
+}
+
+func TestHub_LeaveAllReturnsEveryRoomJoined(t *testing.T) {
+	h := New()
+	alice := &fakeMember{id: "alice"}
+
+	h.Join("general", alice)
+	h.Join("random", alice)
+
+	left := h.LeaveAll(alice)
+	sort.Strings(left)
+	want := []string{"general", "random"}
+	if !reflect.DeepEqual(left, want) {
+		t.Errorf("LeaveAll() = %v, want %v", left, want)
+	}
+	if got := h.List("general"); len(got) != 0 {
+		t.Errorf("List(general) after LeaveAll = %v, want empty", got)
+	}
+}
+
+func TestHub_Kick(t *testing.T) {
+	h := New()
+	alice := &fakeMember{id: "alice"}
+	h.Join("general", alice)
+
+	if ok := h.Kick("general", "bob"); ok {
+		t.Errorf("Kick() for absent member = true, want false")
+	}
+	if ok := h.Kick("general", "alice"); !ok {
+		t.Errorf("Kick() for present member = false, want true")
+	}
+	if got := h.List("general"); len(got) != 0 {
+		t.Errorf("List() after Kick = %v, want empty", got)
+	}
+}
+
+func TestHub_BroadcastDeliversToEveryMemberAndDropsFailures(t *testing.T) {
+	h := New()
+	alice := &fakeMember{id: "alice"}
+	bob := &fakeMember{id: "bob", fail: true}
+	h.Join("general", alice)
+	h.Join("general", bob)
+
+	h.Broadcast("general", []byte("hi"))
+
+	if len(alice.got) != 1 || string(alice.got[0]) != "hi" {
+		t.Errorf("alice.got = %v, want [hi]", alice.got)
+	}
+
+	got := h.List("general")
+	want := []string{"alice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() after broadcast with a failing member = %v, want %v", got, want)
+	}
+}
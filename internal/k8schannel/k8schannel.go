@@ -0,0 +1,63 @@
+// Package k8schannel implements "channel.k8s.io", the binary stream
+// multiplexing subprotocol used by Kubernetes' exec/attach endpoints: the
+// first byte of every binary frame selects a logical stream (stdin,
+// stdout, stderr, error, resize), letting several byte streams share one
+// WebSocket connection.
+package k8schannel
+
+import (
+	"log"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+// Name is the Sec-WebSocket-Protocol token this package negotiates.
+const Name = "channel.k8s.io"
+
+// Channel indices, matching the Kubernetes exec/attach wire protocol.
+const (
+	ChannelStdin  = 0
+	ChannelStdout = 1
+	ChannelStderr = 2
+	ChannelError  = 3
+	ChannelResize = 4
+)
+
+// Protocol is a per-connection channel.k8s.io session. This server has no
+// process to attach stdio to, so as a minimal, testable default it loops
+// stdin back out on stdout; embedding servers that do proxy a real
+// process should replace OnMessage's stdin case with their own plumbing.
+type Protocol struct{}
+
+// New creates a Protocol instance for one connection.
+func New() *Protocol {
+	return &Protocol{}
+}
+
+func (p *Protocol) Name() string { return Name }
+
+func (p *Protocol) OnOpen(conn subprotocol.Conn) {}
+
+func (p *Protocol) OnMessage(conn subprotocol.Conn, opcode byte, payload []byte) {
+	if opcode != wsproto.OpcodeBinary || len(payload) == 0 {
+		return
+	}
+
+	channel, data := payload[0], payload[1:]
+	switch channel {
+	case ChannelStdin:
+		out := make([]byte, 1+len(data))
+		out[0] = ChannelStdout
+		copy(out[1:], data)
+		if err := conn.Send(wsproto.OpcodeBinary, out); err != nil {
+			log.Println("channel.k8s.io: error writing stdout frame:", err)
+		}
+	case ChannelResize:
+		log.Printf("channel.k8s.io: resize request: %q", data)
+	default:
+		log.Printf("channel.k8s.io: unhandled channel %d (%d bytes)", channel, len(data))
+	}
+}
+
+func (p *Protocol) OnClose(conn subprotocol.Conn) {}
@@ -0,0 +1,60 @@
+package k8schannel
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+type fakeConn struct {
+	sent [][]byte
+}
+
+func (f *fakeConn) Send(opcode byte, payload []byte) error {
+	f.sent = append(f.sent, payload)
+	return nil
+}
+
+func (f *fakeConn) Broadcast(opcode byte, payload []byte) {}
+
+func (f *fakeConn) SetUsername(name string) {}
+
+func TestProtocol_StdinLoopsBackToStdout(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeBinary, append([]byte{ChannelStdin}, []byte("ls -la")...))
+
+	if len(conn.sent) != 1 {
+		t.Fatalf("sent = %d frames, want 1", len(conn.sent))
+	}
+	want := append([]byte{ChannelStdout}, []byte("ls -la")...)
+	if !bytes.Equal(conn.sent[0], want) {
+		t.Errorf("sent[0] = %v, want %v", conn.sent[0], want)
+	}
+}
+
+func TestProtocol_IgnoresNonBinaryAndEmptyFrames(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeText, []byte("hello"))
+	p.OnMessage(conn, wsproto.OpcodeBinary, nil)
+
+	if len(conn.sent) != 0 {
+		t.Errorf("sent = %v, want none", conn.sent)
+	}
+}
+
+func TestProtocol_ResizeAndUnknownChannelsDoNotReply(t *testing.T) {
+	p := New()
+	conn := &fakeConn{}
+
+	p.OnMessage(conn, wsproto.OpcodeBinary, []byte{ChannelResize, '8', '0', 'x', '2', '4'})
+	p.OnMessage(conn, wsproto.OpcodeBinary, []byte{ChannelStderr, 'o', 'o', 'p', 's'})
+
+	if len(conn.sent) != 0 {
+		t.Errorf("sent = %v, want none", conn.sent)
+	}
+}
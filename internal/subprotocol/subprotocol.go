@@ -0,0 +1,68 @@
+// Package subprotocol defines the contract between the WebSocket
+// transport and the application-level protocol negotiated via
+// Sec-WebSocket-Protocol during the handshake.
+package subprotocol
+
+import "crypto/x509"
+
+// Conn is the per-connection handle a Protocol uses to talk back to its
+// own peer and to the rest of the server.
+type Conn interface {
+	// Send writes a single message frame to this connection's peer.
+	Send(opcode byte, payload []byte) error
+	// Broadcast writes a message to every connection on the server that
+	// negotiated the same subprotocol as this one, this connection
+	// included.
+	Broadcast(opcode byte, payload []byte)
+	// SetUsername records a human-readable identifier for this
+	// connection, surfaced in logs and diagnostics. A no-op if the
+	// connection already has an identity from a verified client
+	// certificate.
+	SetUsername(name string)
+}
+
+// PresetUsername is implemented by a Conn whose transport already
+// established a verified identity - e.g. a TLS client certificate's CN -
+// before the protocol layer sees any message. A Protocol that otherwise
+// bootstraps its username from the first frame should check for this and
+// skip that step when a preset identity is present, so a verified
+// identity can't be overridden by whatever the peer sends first.
+type PresetUsername interface {
+	// PresetUsername returns the pre-established identity and true, or
+	// ("", false) if the connection has none yet.
+	PresetUsername() (string, bool)
+}
+
+// PeerCertificateProvider is implemented by a Conn whose transport
+// authenticated the peer with a TLS client certificate. Protocols that
+// want to trust the verified chain should type-assert for it rather than
+// requiring it, since most connections won't have one.
+type PeerCertificateProvider interface {
+	// PeerCertificates returns the peer's verified certificate chain,
+	// leaf first, or nil if the connection wasn't client-cert
+	// authenticated.
+	PeerCertificates() []*x509.Certificate
+}
+
+// Protocol implements the application-level behavior for one negotiated
+// Sec-WebSocket-Protocol value. A new instance is created per connection.
+type Protocol interface {
+	// Name is the Sec-WebSocket-Protocol token this implementation
+	// negotiates, e.g. "chat.v1".
+	Name() string
+	// OnOpen is called once the handshake has completed and the
+	// connection is ready to send and receive messages.
+	OnOpen(conn Conn)
+	// OnMessage is called for every text or binary message the peer
+	// sends. Control frames (ping/pong/close) are handled by the server
+	// before reaching the protocol layer.
+	OnMessage(conn Conn, opcode byte, payload []byte)
+	// OnClose is called once per connection: when the peer's close frame
+	// arrives, before the reply is queued, or otherwise once the server
+	// stops reading (a read error, a protocol violation or a keepalive
+	// timeout).
+	OnClose(conn Conn)
+}
+
+// Factory creates a new Protocol instance for one connection.
+type Factory func() Protocol
@@ -0,0 +1,137 @@
+package wsproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// deflateTrailer is the 4-byte marker RFC 7692 section 7.2.1 says a
+// compressor emits at a sync-flush boundary, and that senders must strip
+// before putting the result on the wire; receivers add it back before
+// inflating.
+var deflateTrailer = []byte{0x00, 0x00, 0xff, 0xff}
+
+// maxWindowSize is the largest LZ77 window compress/flate supports; it's
+// used to cap how much decompressed history we keep around as a preset
+// dictionary for context takeover between messages.
+const maxWindowSize = 32768
+
+// CompressionConfig describes a negotiated permessage-deflate extension
+// (RFC 7692) for one connection.
+type CompressionConfig struct {
+	// Level is the flate compression level used for outbound messages;
+	// 0 means flate.DefaultCompression.
+	Level int
+	// ClientNoContextTakeover mirrors the client_no_context_takeover
+	// extension parameter: the client resets its compression state after
+	// every message, so our decompressor must too.
+	ClientNoContextTakeover bool
+	// ServerNoContextTakeover mirrors server_no_context_takeover: we
+	// reset our own compressor's sliding window after every message
+	// instead of carrying it forward.
+	ServerNoContextTakeover bool
+	// Threshold is the minimum payload size, in bytes, worth compressing.
+	// Messages smaller than this are sent uncompressed.
+	Threshold int
+}
+
+type compressionState struct {
+	cfg      CompressionConfig
+	inbound  *decompressor
+	outbound *compressor
+}
+
+// EnableCompression turns on permessage-deflate framing for this
+// connection using the parameters the handshake negotiated.
+func (c *Conn) EnableCompression(cfg CompressionConfig) {
+	level := cfg.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	c.compression = &compressionState{
+		cfg:      cfg,
+		inbound:  newDecompressor(cfg.ClientNoContextTakeover),
+		outbound: newCompressor(level, cfg.ServerNoContextTakeover),
+	}
+}
+
+// compressor deflates outbound message payloads, keeping its sliding
+// window across messages unless noContextTakeover is set.
+type compressor struct {
+	buf               *bytes.Buffer
+	fw                *flate.Writer
+	noContextTakeover bool
+}
+
+func newCompressor(level int, noContextTakeover bool) *compressor {
+	buf := new(bytes.Buffer)
+	fw, _ := flate.NewWriter(buf, level)
+	return &compressor{buf: buf, fw: fw, noContextTakeover: noContextTakeover}
+}
+
+func (c *compressor) compress(payload []byte) ([]byte, error) {
+	c.buf.Reset()
+	if _, err := c.fw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := c.fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	out := bytes.TrimSuffix(c.buf.Bytes(), deflateTrailer)
+	result := make([]byte, len(out))
+	copy(result, out)
+
+	if c.noContextTakeover {
+		c.fw.Reset(c.buf)
+	}
+	return result, nil
+}
+
+// decompressor inflates inbound message payloads. Go's compress/flate
+// treats a Read error as permanent, so rather than keep feeding one
+// long-lived Reader across sync-flush boundaries (which would latch the
+// io.ErrUnexpectedEOF each message ends with), it resets the Reader for
+// every message and, for context takeover, carries the trailing window
+// of previously decompressed bytes forward as a preset dictionary.
+type decompressor struct {
+	in                *bytes.Buffer
+	fr                io.ReadCloser
+	dict              []byte
+	noContextTakeover bool
+}
+
+func newDecompressor(noContextTakeover bool) *decompressor {
+	in := new(bytes.Buffer)
+	return &decompressor{in: in, fr: flate.NewReader(in), noContextTakeover: noContextTakeover}
+}
+
+func (d *decompressor) decompress(payload []byte) ([]byte, error) {
+	d.in.Reset()
+	d.in.Write(payload)
+	d.in.Write(deflateTrailer)
+	d.fr.(flate.Resetter).Reset(d.in, d.dict)
+
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, d.fr); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	if d.noContextTakeover {
+		d.dict = nil
+	} else {
+		d.dict = appendWindow(d.dict, out.Bytes())
+	}
+	return out.Bytes(), nil
+}
+
+// appendWindow grows dict with newData, capping it at the largest window
+// compress/flate can use as a preset dictionary.
+func appendWindow(dict, newData []byte) []byte {
+	dict = append(dict, newData...)
+	if len(dict) > maxWindowSize {
+		dict = dict[len(dict)-maxWindowSize:]
+	}
+	return dict
+}
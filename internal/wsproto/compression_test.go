@@ -0,0 +1,127 @@
+package wsproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+)
+
+func TestCompressorDecompressor_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name              string
+		noContextTakeover bool
+	}{
+		{"context takeover", false},
+		{"no context takeover", true},
+	}
+
+	messages := []string{
+		"hello",
+		"hello again, world",
+		"a third message that reuses the sliding window dictionary",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCompressor(flate.DefaultCompression, tt.noContextTakeover)
+			d := newDecompressor(tt.noContextTakeover)
+
+			for _, want := range messages {
+				compressed, err := c.compress([]byte(want))
+				if err != nil {
+					t.Fatalf("compress() error = %v", err)
+				}
+				got, err := d.decompress(compressed)
+				if err != nil {
+					t.Fatalf("decompress() error = %v", err)
+				}
+				if string(got) != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConn_ReadMessage_DecompressesNegotiatedPayload(t *testing.T) {
+	c := newCompressor(flate.DefaultCompression, false)
+	compressed, err := c.compress([]byte("hello, compressed world"))
+	if err != nil {
+		t.Fatalf("compress() error = %v", err)
+	}
+
+	wire := buildClientFrame(true, true, false, false, OpcodeText, compressed)
+	conn := newTestConn(wire)
+	conn.EnableCompression(CompressionConfig{})
+
+	opcode, payload, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if opcode != OpcodeText || string(payload) != "hello, compressed world" {
+		t.Errorf("got (%x, %q), want (%x, %q)", opcode, payload, OpcodeText, "hello, compressed world")
+	}
+}
+
+func TestConn_ReadMessage_RejectsRSV1WithoutCompression(t *testing.T) {
+	wire := buildClientFrame(true, true, false, false, OpcodeText, []byte("x"))
+	conn := newTestConn(wire)
+
+	_, _, err := conn.ReadMessage()
+	protoErr, ok := err.(*ProtocolError)
+	if !ok {
+		t.Fatalf("ReadMessage() error = %v, want *ProtocolError", err)
+	}
+	if protoErr.Code != CloseProtocolError {
+		t.Errorf("Code = %d, want %d", protoErr.Code, CloseProtocolError)
+	}
+}
+
+func TestConn_WriteMessage_CompressesAboveThreshold(t *testing.T) {
+	var wire bytes.Buffer
+	conn := NewConn(&wire)
+	conn.EnableCompression(CompressionConfig{Threshold: 5})
+
+	payload := []byte("this message is long enough to be compressed")
+	if err := conn.WriteMessage(OpcodeText, payload); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	frame, err := readFrame(&wire)
+	if err != nil {
+		t.Fatalf("readFrame() error = %v", err)
+	}
+	if !frame.RSV1 {
+		t.Fatalf("RSV1 not set on compressed frame")
+	}
+
+	d := newDecompressor(false)
+	got, err := d.decompress(frame.Payload)
+	if err != nil {
+		t.Fatalf("decompress() error = %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("got %q, want %q", got, payload)
+	}
+}
+
+func TestConn_WriteMessage_SkipsCompressionBelowThreshold(t *testing.T) {
+	var wire bytes.Buffer
+	conn := NewConn(&wire)
+	conn.EnableCompression(CompressionConfig{Threshold: 100})
+
+	if err := conn.WriteMessage(OpcodeText, []byte("short")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	frame, err := readFrame(&wire)
+	if err != nil {
+		t.Fatalf("readFrame() error = %v", err)
+	}
+	if frame.RSV1 {
+		t.Errorf("RSV1 set for payload under threshold")
+	}
+	if string(frame.Payload) != "short" {
+		t.Errorf("payload = %q, want %q", frame.Payload, "short")
+	}
+}
@@ -0,0 +1,204 @@
+package wsproto
+
+import (
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// deadlineSetter is implemented by transports that support per-operation
+// timeouts, such as net.Conn. Conn uses it, when present, to bound every
+// read and write so a stuck handshake or half-open connection can't leak
+// a goroutine.
+type deadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Conn wraps a raw byte stream and speaks RFC 6455 framing over it: it
+// reassembles fragmented messages, validates control frames and reserved
+// bits, and emits frames the way a server must (always unmasked).
+type Conn struct {
+	rw        io.ReadWriter
+	deadlines deadlineSetter // nil if rw doesn't support deadlines
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	fragmenting    bool
+	fragOpcode     byte
+	fragPayload    []byte
+	fragCompressed bool
+
+	compression *compressionState
+}
+
+// NewConn wraps rw (typically a net.Conn) in a WebSocket frame codec.
+func NewConn(rw io.ReadWriter) *Conn {
+	c := &Conn{rw: rw}
+	c.deadlines, _ = rw.(deadlineSetter)
+	return c
+}
+
+// SetTimeouts bounds how long each read and write may take before
+// failing with a timeout error; zero disables the corresponding
+// deadline. It has no effect if the wrapped transport doesn't support
+// deadlines.
+func (c *Conn) SetTimeouts(read, write time.Duration) {
+	c.readTimeout = read
+	c.writeTimeout = write
+}
+
+func (c *Conn) applyReadDeadline() {
+	if c.deadlines == nil || c.readTimeout <= 0 {
+		return
+	}
+	c.deadlines.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
+func (c *Conn) applyWriteDeadline() {
+	if c.deadlines == nil || c.writeTimeout <= 0 {
+		return
+	}
+	c.deadlines.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+}
+
+// ReadMessage reads frames until a complete data message (text or
+// binary) or a control frame is available, reassembling continuation
+// frames as needed. Control frames are returned as soon as they arrive,
+// even in the middle of a fragmented data message, since RFC 6455 allows
+// them to be interleaved. It returns a *ProtocolError when the peer
+// violates the framing rules; callers should respond with a close frame
+// carrying that error's Code.
+func (c *Conn) ReadMessage() (opcode byte, payload []byte, err error) {
+	for {
+		c.applyReadDeadline()
+		frame, err := readFrame(c.rw)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if frame.RSV2 || frame.RSV3 {
+			return 0, nil, newProtocolError(CloseProtocolError, "reserved bits set without a negotiated extension")
+		}
+		if frame.RSV1 && (c.compression == nil || isControlOpcode(frame.Opcode) || frame.Opcode == OpcodeContinuation) {
+			return 0, nil, newProtocolError(CloseProtocolError, "RSV1 set without a negotiated compression extension")
+		}
+		if !frame.Masked {
+			return 0, nil, newProtocolError(CloseProtocolError, "client frames must be masked")
+		}
+
+		if isControlOpcode(frame.Opcode) {
+			return frame.Opcode, frame.Payload, nil
+		}
+
+		switch frame.Opcode {
+		case OpcodeContinuation:
+			if !c.fragmenting {
+				return 0, nil, newProtocolError(CloseProtocolError, "continuation frame without a preceding fragment")
+			}
+			c.fragPayload = append(c.fragPayload, frame.Payload...)
+			if len(c.fragPayload) > maxMessageSize {
+				c.fragmenting = false
+				c.fragOpcode, c.fragPayload, c.fragCompressed = 0, nil, false
+				return 0, nil, newProtocolError(CloseMessageTooBig, "reassembled message exceeds maximum allowed size")
+			}
+			if !frame.Fin {
+				continue
+			}
+			opcode, payload := c.fragOpcode, c.fragPayload
+			compressed := c.fragCompressed
+			c.fragmenting = false
+			c.fragOpcode, c.fragPayload, c.fragCompressed = 0, nil, false
+			return c.finishMessage(opcode, payload, compressed)
+
+		case OpcodeText, OpcodeBinary:
+			if c.fragmenting {
+				return 0, nil, newProtocolError(CloseProtocolError, "expected continuation frame, got new message")
+			}
+			if frame.Fin {
+				return c.finishMessage(frame.Opcode, frame.Payload, frame.RSV1)
+			}
+			c.fragmenting = true
+			c.fragOpcode = frame.Opcode
+			c.fragPayload = append([]byte{}, frame.Payload...)
+			c.fragCompressed = frame.RSV1
+
+		default:
+			return 0, nil, newProtocolError(CloseProtocolError, "unknown opcode")
+		}
+	}
+}
+
+// finishMessage inflates payload when compressed indicates the message
+// carried a negotiated permessage-deflate extension.
+func (c *Conn) finishMessage(opcode byte, payload []byte, compressed bool) (byte, []byte, error) {
+	if !compressed {
+		return opcode, payload, nil
+	}
+	inflated, err := c.compression.inbound.decompress(payload)
+	if err != nil {
+		return 0, nil, newProtocolError(CloseInvalidPayload, "failed to inflate permessage-deflate payload")
+	}
+	return opcode, inflated, nil
+}
+
+// WriteMessage sends payload as a single, unfragmented server frame. When
+// permessage-deflate has been negotiated and payload meets the configured
+// threshold, it is compressed and sent with RSV1 set.
+func (c *Conn) WriteMessage(opcode byte, payload []byte) error {
+	frame := Frame{Fin: true, Opcode: opcode, Payload: payload}
+
+	if c.compression != nil && !isControlOpcode(opcode) && len(payload) >= c.compression.cfg.Threshold {
+		compressed, err := c.compression.outbound.compress(payload)
+		if err != nil {
+			return err
+		}
+		frame.RSV1 = true
+		frame.Payload = compressed
+	}
+
+	c.applyWriteDeadline()
+	return writeFrame(c.rw, frame)
+}
+
+// WriteClose sends a close frame carrying the given status code and
+// reason, as the first half of the RFC 6455 closing handshake. Callers
+// are still responsible for closing the underlying transport afterwards.
+func (c *Conn) WriteClose(code int, reason string) error {
+	c.applyWriteDeadline()
+	return writeFrame(c.rw, Frame{Fin: true, Opcode: OpcodeClose, Payload: encodeClosePayload(code, reason)})
+}
+
+func encodeClosePayload(code int, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+	payload := make([]byte, 2+len(reason))
+	payload[0] = byte(code >> 8)
+	payload[1] = byte(code)
+	copy(payload[2:], reason)
+	return payload
+}
+
+// ParseClose decodes the payload of an incoming close frame into its
+// status code and UTF-8 reason. An empty payload is a valid close with
+// no status code (RFC 6455 section 7.1.5 treats this as 1005). A
+// malformed payload - a lone status-code byte, or a reason that isn't
+// valid UTF-8 - yields a *ProtocolError with the close code the server
+// must echo back.
+func ParseClose(payload []byte) (code int, reason string, err error) {
+	if len(payload) == 0 {
+		return CloseNoStatusRcvd, "", nil
+	}
+	if len(payload) == 1 {
+		return 0, "", newProtocolError(CloseProtocolError, "close frame payload must be 0 or at least 2 bytes")
+	}
+
+	code = int(payload[0])<<8 | int(payload[1])
+	reason = string(payload[2:])
+	if !utf8.ValidString(reason) {
+		return code, "", newProtocolError(CloseInvalidPayload, "close reason is not valid UTF-8")
+	}
+	return code, reason, nil
+}
@@ -0,0 +1,132 @@
+package wsproto
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Frame is a single RFC 6455 WebSocket frame.
+type Frame struct {
+	Fin    bool
+	RSV1   bool
+	RSV2   bool
+	RSV3   bool
+	Opcode byte
+	Masked bool
+
+	Payload []byte
+}
+
+// readFrame decodes exactly one frame from r. It does not interpret
+// fragmentation; callers assemble multi-frame messages themselves.
+func readFrame(r io.Reader) (Frame, error) {
+	var frame Frame
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame, err
+	}
+
+	frame.Fin = header[0]&0x80 != 0
+	frame.RSV1 = header[0]&0x40 != 0
+	frame.RSV2 = header[0]&0x20 != 0
+	frame.RSV3 = header[0]&0x10 != 0
+	frame.Opcode = header[0] & 0x0F
+
+	frame.Masked = header[1]&0x80 != 0
+	payloadLen := int(header[1] & 0x7F)
+
+	switch payloadLen {
+	case 126:
+		var extended uint16
+		if err := binary.Read(r, binary.BigEndian, &extended); err != nil {
+			return frame, err
+		}
+		payloadLen = int(extended)
+	case 127:
+		var extended uint64
+		if err := binary.Read(r, binary.BigEndian, &extended); err != nil {
+			return frame, err
+		}
+		if extended > uint64(maxFramePayload) {
+			return frame, newProtocolError(CloseMessageTooBig, "frame payload exceeds maximum allowed size")
+		}
+		payloadLen = int(extended)
+	}
+
+	if payloadLen > maxFramePayload {
+		return frame, newProtocolError(CloseMessageTooBig, "frame payload exceeds maximum allowed size")
+	}
+
+	if isControlOpcode(frame.Opcode) {
+		if !frame.Fin {
+			return frame, newProtocolError(CloseProtocolError, "control frames must not be fragmented")
+		}
+		if payloadLen > maxControlFramePayload {
+			return frame, newProtocolError(CloseProtocolError, "control frame payload exceeds 125 bytes")
+		}
+	}
+
+	var maskingKey [4]byte
+	if frame.Masked {
+		if _, err := io.ReadFull(r, maskingKey[:]); err != nil {
+			return frame, err
+		}
+	}
+
+	frame.Payload = make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return frame, err
+	}
+
+	if frame.Masked {
+		for i := range frame.Payload {
+			frame.Payload[i] ^= maskingKey[i%4]
+		}
+	}
+
+	return frame, nil
+}
+
+// writeFrame encodes a single, unmasked frame (servers must not mask
+// frames they send) and writes it to w.
+func writeFrame(w io.Writer, f Frame) error {
+	if isControlOpcode(f.Opcode) && len(f.Payload) > maxControlFramePayload {
+		return newProtocolError(CloseProtocolError, "control frame payload exceeds 125 bytes")
+	}
+
+	var buffer []byte
+
+	firstByte := f.Opcode
+	if f.Fin {
+		firstByte |= 0x80
+	}
+	if f.RSV1 {
+		firstByte |= 0x40
+	}
+	if f.RSV2 {
+		firstByte |= 0x20
+	}
+	if f.RSV3 {
+		firstByte |= 0x10
+	}
+	buffer = append(buffer, firstByte)
+
+	payloadLen := len(f.Payload)
+	switch {
+	case payloadLen <= 125:
+		buffer = append(buffer, byte(payloadLen))
+	case payloadLen <= 65535:
+		buffer = append(buffer, 126, byte(payloadLen>>8), byte(payloadLen&0xFF))
+	default:
+		buffer = append(buffer, 127)
+		for i := 7; i >= 0; i-- {
+			buffer = append(buffer, byte(payloadLen>>(i*8)))
+		}
+	}
+
+	buffer = append(buffer, f.Payload...)
+
+	_, err := w.Write(buffer)
+	return err
+}
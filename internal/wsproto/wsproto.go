@@ -0,0 +1,69 @@
+// Package wsproto implements the RFC 6455 WebSocket framing protocol:
+// frame encoding/decoding, fragmentation reassembly, control-frame
+// validation and the close handshake. It is transport-agnostic - callers
+// supply anything that satisfies io.ReadWriter (typically a net.Conn).
+package wsproto
+
+// Opcodes as defined in RFC 6455 section 5.2.
+const (
+	OpcodeContinuation = 0x0
+	OpcodeText         = 0x1
+	OpcodeBinary       = 0x2
+	OpcodeClose        = 0x8
+	OpcodePing         = 0x9
+	OpcodePong         = 0xA
+)
+
+// Close status codes from RFC 6455 section 7.4.1. This package itself
+// produces CloseProtocolError, CloseInvalidPayload and CloseMessageTooBig
+// (in a *ProtocolError) and CloseNoStatusRcvd (from ParseClose); the
+// rest are for callers, and subprotocols are free to send others.
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatusRcvd    = 1005
+	CloseAbnormalClosure = 1006
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+	CloseInternalErr     = 1011
+)
+
+// maxControlFramePayload is the RFC 6455 section 5.5 limit on control
+// frame payload length.
+const maxControlFramePayload = 125
+
+// maxFramePayload bounds a single frame's declared payload length, and
+// maxMessageSize bounds a fully reassembled message once continuation
+// frames are joined. maxFramePayload is checked before the payload is
+// allocated or read, and maxMessageSize each time a continuation frame is
+// appended, so a forged length field (including a 64-bit extended length
+// whose high bit makes the cast to int negative) can't crash the process
+// with an invalid makeslice or force an unbounded allocation. They're vars
+// rather than consts so tests can tighten them without needing
+// multi-megabyte fixtures.
+var (
+	maxFramePayload = 16 << 20 // 16 MiB
+	maxMessageSize  = 16 << 20 // 16 MiB
+)
+
+func isControlOpcode(opcode byte) bool {
+	return opcode == OpcodeClose || opcode == OpcodePing || opcode == OpcodePong
+}
+
+// ProtocolError is returned when a peer violates RFC 6455 framing rules.
+// Code is the close status code the caller should send back before
+// dropping the connection.
+type ProtocolError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Msg
+}
+
+func newProtocolError(code int, msg string) error {
+	return &ProtocolError{Code: code, Msg: msg}
+}
@@ -0,0 +1,354 @@
+package wsproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// readWriter adapts a reader with a discarded writer so tests can feed
+// canned wire bytes into a Conn, which requires io.ReadWriter.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func newTestConn(wire []byte) *Conn {
+	return NewConn(readWriter{Reader: bytes.NewReader(wire), Writer: io.Discard})
+}
+
+// maskPayload returns a copy of payload masked as an RFC 6455 client
+// frame would mask it.
+func maskPayload(key [4]byte, payload []byte) []byte {
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ key[i%4]
+	}
+	return masked
+}
+
+// buildClientFrame assembles a masked client-to-server frame, mirroring
+// the wire layouts exercised by the Autobahn Testsuite's fragmentation
+// and control-frame cases.
+func buildClientFrame(fin bool, rsv1, rsv2, rsv3 bool, opcode byte, payload []byte) []byte {
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	if rsv2 {
+		first |= 0x20
+	}
+	if rsv3 {
+		first |= 0x10
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(first)
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		buf.WriteByte(0x80 | byte(length))
+	case length <= 65535:
+		buf.WriteByte(0x80 | 126)
+		buf.WriteByte(byte(length >> 8))
+		buf.WriteByte(byte(length & 0xFF))
+	default:
+		buf.WriteByte(0x80 | 127)
+		for i := 7; i >= 0; i-- {
+			buf.WriteByte(byte(length >> (i * 8)))
+		}
+	}
+
+	buf.Write(key[:])
+	buf.Write(maskPayload(key, payload))
+	return buf.Bytes()
+}
+
+func TestReadMessage_SingleFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		opcode  byte
+		payload []byte
+	}{
+		{"empty text", OpcodeText, []byte{}},
+		{"short text", OpcodeText, []byte("hello")},
+		{"binary", OpcodeBinary, []byte{0x00, 0xFF, 0x10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire := buildClientFrame(true, false, false, false, tt.opcode, tt.payload)
+			conn := newTestConn(wire)
+
+			opcode, payload, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v", err)
+			}
+			if opcode != tt.opcode {
+				t.Errorf("opcode = %x, want %x", opcode, tt.opcode)
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("payload = %v, want %v", payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestReadFrame_RejectsOversizedPayloadBeforeAllocating(t *testing.T) {
+	origMax := maxFramePayload
+	maxFramePayload = 4
+	defer func() { maxFramePayload = origMax }()
+
+	// The claimed length (10) exceeds maxFramePayload, but the wire
+	// carries no masking key or payload bytes at all: if the size check
+	// ran after reading, io.ReadFull would fail with an EOF error instead
+	// of the *ProtocolError asserted below.
+	wire := buildClientFrame(true, false, false, false, OpcodeBinary, make([]byte, 10))[:2]
+
+	conn := newTestConn(wire)
+	_, _, err := conn.ReadMessage()
+
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("ReadMessage() error = %v, want *ProtocolError", err)
+	}
+	if protoErr.Code != CloseMessageTooBig {
+		t.Errorf("Code = %d, want %d", protoErr.Code, CloseMessageTooBig)
+	}
+}
+
+func TestReadMessage_Fragmentation(t *testing.T) {
+	var wire []byte
+	wire = append(wire, buildClientFrame(false, false, false, false, OpcodeText, []byte("Hel"))...)
+	wire = append(wire, buildClientFrame(false, false, false, false, OpcodeContinuation, []byte("lo, "))...)
+	wire = append(wire, buildClientFrame(true, false, false, false, OpcodeContinuation, []byte("World!"))...)
+
+	conn := newTestConn(wire)
+	opcode, payload, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if opcode != OpcodeText {
+		t.Errorf("opcode = %x, want %x", opcode, OpcodeText)
+	}
+	if string(payload) != "Hello, World!" {
+		t.Errorf("payload = %q, want %q", payload, "Hello, World!")
+	}
+}
+
+func TestReadMessage_ContinuationAccumulationExceedsMaxMessageSize(t *testing.T) {
+	origMax := maxMessageSize
+	maxMessageSize = 5
+	defer func() { maxMessageSize = origMax }()
+
+	var wire []byte
+	wire = append(wire, buildClientFrame(false, false, false, false, OpcodeText, []byte("Hel"))...)
+	wire = append(wire, buildClientFrame(true, false, false, false, OpcodeContinuation, []byte("lo, World!"))...)
+
+	conn := newTestConn(wire)
+	_, _, err := conn.ReadMessage()
+
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("ReadMessage() error = %v, want *ProtocolError", err)
+	}
+	if protoErr.Code != CloseMessageTooBig {
+		t.Errorf("Code = %d, want %d", protoErr.Code, CloseMessageTooBig)
+	}
+}
+
+func TestReadMessage_PingInterleavedWithFragmentation(t *testing.T) {
+	var wire []byte
+	wire = append(wire, buildClientFrame(false, false, false, false, OpcodeText, []byte("part1"))...)
+	wire = append(wire, buildClientFrame(true, false, false, false, OpcodePing, []byte("ping-payload"))...)
+	wire = append(wire, buildClientFrame(true, false, false, false, OpcodeContinuation, []byte("part2"))...)
+
+	conn := newTestConn(wire)
+
+	opcode, payload, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() (ping) error = %v", err)
+	}
+	if opcode != OpcodePing || string(payload) != "ping-payload" {
+		t.Errorf("got opcode %x payload %q, want ping/ping-payload", opcode, payload)
+	}
+
+	opcode, payload, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() (text) error = %v", err)
+	}
+	if opcode != OpcodeText || string(payload) != "part1part2" {
+		t.Errorf("got opcode %x payload %q, want text/part1part2", opcode, payload)
+	}
+}
+
+func TestReadMessage_ProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		wire     []byte
+		wantCode int
+	}{
+		{
+			name:     "unmasked client frame",
+			wire:     []byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'},
+			wantCode: CloseProtocolError,
+		},
+		{
+			name:     "reserved bit set",
+			wire:     buildClientFrame(true, true, false, false, OpcodeText, []byte("x")),
+			wantCode: CloseProtocolError,
+		},
+		{
+			name: "fragmented control frame",
+			wire: func() []byte {
+				b := buildClientFrame(false, false, false, false, OpcodePing, []byte("x"))
+				b[0] &^= 0x80 // clear FIN
+				return b
+			}(),
+			wantCode: CloseProtocolError,
+		},
+		{
+			name:     "oversized control frame",
+			wire:     buildClientFrame(true, false, false, false, OpcodePing, bytes.Repeat([]byte{'x'}, 126)),
+			wantCode: CloseProtocolError,
+		},
+		{
+			name:     "continuation without preceding fragment",
+			wire:     buildClientFrame(true, false, false, false, OpcodeContinuation, []byte("x")),
+			wantCode: CloseProtocolError,
+		},
+		{
+			name: "extended length with high bit set",
+			wire: func() []byte {
+				b := buildClientFrame(true, false, false, false, OpcodeBinary, []byte("x"))
+				// Overwrite the 1-byte length with a 64-bit extended
+				// length field claiming the maximum possible size,
+				// mirroring a forged frame with no real payload
+				// behind it.
+				header := []byte{b[0], 0x80 | 127, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+				return append(header, b[2:]...)
+			}(),
+			wantCode: CloseMessageTooBig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConn(tt.wire)
+			_, _, err := conn.ReadMessage()
+
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("ReadMessage() error = %v, want *ProtocolError", err)
+			}
+			if protoErr.Code != tt.wantCode {
+				t.Errorf("Code = %d, want %d", protoErr.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteMessage_ServerFramesAreUnmasked(t *testing.T) {
+	var buf bytes.Buffer
+	conn := NewConn(&buf)
+
+	if err := conn.WriteMessage(OpcodeText, []byte("hi")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	wire := buf.Bytes()
+	if wire[0] != 0x81 {
+		t.Errorf("first byte = %#x, want FIN+text (0x81)", wire[0])
+	}
+	if wire[1]&0x80 != 0 {
+		t.Errorf("mask bit set on server frame, want unmasked")
+	}
+}
+
+// deadlineRecorder implements deadlineSetter on top of a plain
+// io.ReadWriter, so tests can assert Conn applies read/write deadlines
+// without needing a real net.Conn.
+type deadlineRecorder struct {
+	io.Reader
+	io.Writer
+	readDeadlines  []time.Time
+	writeDeadlines []time.Time
+}
+
+func (d *deadlineRecorder) SetReadDeadline(t time.Time) error {
+	d.readDeadlines = append(d.readDeadlines, t)
+	return nil
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	d.writeDeadlines = append(d.writeDeadlines, t)
+	return nil
+}
+
+func TestConn_SetTimeouts_AppliesDeadlinesToReadsAndWrites(t *testing.T) {
+	wire := buildClientFrame(true, false, false, false, OpcodeText, []byte("hi"))
+	rec := &deadlineRecorder{Reader: bytes.NewReader(wire), Writer: io.Discard}
+	conn := NewConn(rec)
+	conn.SetTimeouts(5*time.Second, 2*time.Second)
+
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if len(rec.readDeadlines) != 1 {
+		t.Fatalf("readDeadlines = %d, want 1", len(rec.readDeadlines))
+	}
+
+	if err := conn.WriteMessage(OpcodeText, []byte("hi")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if len(rec.writeDeadlines) != 1 {
+		t.Fatalf("writeDeadlines = %d, want 1", len(rec.writeDeadlines))
+	}
+}
+
+func TestConn_SetTimeouts_NoopWithoutDeadlineSupport(t *testing.T) {
+	conn := newTestConn(buildClientFrame(true, false, false, false, OpcodeText, []byte("hi")))
+	conn.SetTimeouts(5*time.Second, 2*time.Second)
+
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error = %v, want nil even without deadline support", err)
+	}
+}
+
+func TestParseClose(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		wantCode   int
+		wantReason string
+		wantErr    bool
+	}{
+		{"no status", nil, CloseNoStatusRcvd, "", false},
+		{"normal with reason", append([]byte{0x03, 0xE8}, "bye"...), CloseNormalClosure, "bye", false},
+		{"truncated code", []byte{0x03}, 0, "", true},
+		{"invalid utf8 reason", append([]byte{0x03, 0xE8}, 0xFF), CloseNormalClosure, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason, err := ParseClose(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if code != tt.wantCode || reason != tt.wantReason {
+				t.Errorf("got (%d, %q), want (%d, %q)", code, reason, tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+// PingInterval is how often a Client's keepalive goroutine sends a ping;
+// 0 disables keepalive entirely.
+var PingInterval = 30 * time.Second
+
+// PongTimeout is how long past PingInterval a Client may go without any
+// pong: once PingInterval+PongTimeout has passed since the last pong, the
+// next keepalive tick closes its connection with code 1011 (internal
+// error).
+var PongTimeout = 10 * time.Second
+
+// keepalive sends a ping every interval with a monotonically increasing
+// payload and closes the connection with CloseInternalErr once interval+
+// timeout has passed without a pong. Staleness is checked against the
+// ticker's own cadence rather than a second blocking sleep per cycle, so
+// PingInterval is honored even when PongTimeout is configured to be
+// equal to or longer than it. It exits once writeDone closes.
+func (c *Client) keepalive(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+
+	for {
+		select {
+		case <-c.writeDone:
+			return
+		case <-ticker.C:
+			lastSeen := start
+			if lastPong := atomic.LoadInt64(&c.lastPong); lastPong != 0 {
+				lastSeen = time.Unix(0, lastPong)
+			}
+			if time.Since(lastSeen) > interval+timeout {
+				atomic.AddInt64(&metricPongTimeouts, 1)
+				log.Printf("Ping timeout for %s\n", c.Username)
+				c.enqueueClose(wsproto.CloseInternalErr, "ping timeout")
+				return
+			}
+
+			n := atomic.AddUint64(&c.pingCounter, 1)
+			payload := make([]byte, 8)
+			binary.BigEndian.PutUint64(payload, n)
+			if !c.enqueue(wsproto.OpcodePing, payload) {
+				return
+			}
+			atomic.AddInt64(&metricPingsSent, 1)
+		}
+	}
+}
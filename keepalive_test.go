@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Keepalive_ClosesAfterPongTimeout(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+
+	c := newClient(server, nil)
+	before := atomic.LoadInt64(&metricPongTimeouts)
+
+	go c.writeLoop()
+	go c.keepalive(10*time.Millisecond, 20*time.Millisecond)
+
+	// Drain everything the server sends without ever replying with a
+	// pong, simulating a dead peer.
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			if _, err := peer.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-c.writeDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writeLoop never exited after a ping timeout")
+	}
+
+	if got := atomic.LoadInt64(&metricPongTimeouts); got <= before {
+		t.Errorf("metricPongTimeouts = %d, want > %d", got, before)
+	}
+}
+
+func TestClient_Keepalive_SurvivesOnTimelyPong(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	defer server.Close()
+
+	c := newClient(server, nil)
+
+	go c.writeLoop()
+	go c.keepalive(10*time.Millisecond, 50*time.Millisecond)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			if _, err := peer.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Simulate the peer answering a ping promptly, without needing a full
+	// frame codec on this side: just refresh lastPong once.
+	time.Sleep(15 * time.Millisecond)
+	atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+
+	select {
+	case <-c.writeDone:
+		t.Fatal("writeLoop exited even though a fresh pong was recorded")
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+// TestClient_Keepalive_HonorsPingIntervalRegardlessOfPongTimeout guards
+// against the ping cadence collapsing toward PongTimeout when it's
+// configured to be as long as or longer than PingInterval: pings must
+// keep firing every interval, not every interval+timeout.
+func TestClient_Keepalive_HonorsPingIntervalRegardlessOfPongTimeout(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	defer server.Close()
+
+	c := newClient(server, nil)
+	before := atomic.LoadInt64(&metricPingsSent)
+
+	go c.writeLoop()
+	go c.keepalive(5*time.Millisecond, 50*time.Millisecond)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Drain pings without a full frame codec on this side.
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			if _, err := peer.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Keep lastPong fresher than PongTimeout so the connection survives
+	// long enough to observe several ping intervals.
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+			}
+		}
+	}()
+
+	time.Sleep(120 * time.Millisecond)
+
+	if got := atomic.LoadInt64(&metricPingsSent) - before; got < 15 {
+		t.Errorf("metricPingsSent increased by %d over 120ms at a 5ms interval, want at least 15", got)
+	}
+}
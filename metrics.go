@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Prometheus-style counters, updated by the keepalive goroutine and read
+// by handleMetrics.
+var (
+	metricPingsSent    int64
+	metricPongTimeouts int64
+)
+
+func init() {
+	http.HandleFunc("/metrics", handleMetrics)
+}
+
+// handleMetrics serves a minimal Prometheus text-exposition-format
+// snapshot of this server's connection and keepalive counters.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	connMutex.Lock()
+	active := len(connections)
+	connMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE ws_pings_sent_total counter\n")
+	fmt.Fprintf(w, "ws_pings_sent_total %d\n", atomic.LoadInt64(&metricPingsSent))
+	fmt.Fprintf(w, "# TYPE ws_pong_timeout_total counter\n")
+	fmt.Fprintf(w, "ws_pong_timeout_total %d\n", atomic.LoadInt64(&metricPongTimeouts))
+	fmt.Fprintf(w, "# TYPE ws_active_connections gauge\n")
+	fmt.Fprintf(w, "ws_active_connections %d\n", active)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/chatv1"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/chatv2"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/hub"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/k8schannel"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+)
+
+// chatHub backs every chat.v2 session; it's shared across connections so
+// rooms and presence are visible server-wide.
+var chatHub = hub.New()
+
+// protocolFactories holds every Sec-WebSocket-Protocol this server can
+// negotiate, keyed by its wire name.
+var protocolFactories = map[string]subprotocol.Factory{
+	chatv1.Name:     func() subprotocol.Protocol { return chatv1.New() },
+	chatv2.Name:     func() subprotocol.Protocol { return chatv2.New(chatHub, nil) },
+	k8schannel.Name: func() subprotocol.Protocol { return k8schannel.New() },
+}
+
+// negotiateSubprotocol picks the first protocol in the client's offered,
+// comma-separated Sec-WebSocket-Protocol list that this server supports.
+// If the client didn't offer any, it falls back to chat.v1 to preserve
+// this server's original behavior for plain clients, and leaves ok false
+// so the caller knows not to echo a Sec-WebSocket-Protocol response
+// header (RFC 6455 section 4.2.2 only allows echoing an offered value).
+func negotiateSubprotocol(header string) (factory subprotocol.Factory, name string, ok bool) {
+	if header == "" {
+		return protocolFactories[chatv1.Name], chatv1.Name, false
+	}
+
+	for _, offered := range strings.Split(header, ",") {
+		offered = strings.TrimSpace(offered)
+		if f, found := protocolFactories[offered]; found {
+			return f, offered, true
+		}
+	}
+
+	return protocolFactories[chatv1.Name], chatv1.Name, false
+}
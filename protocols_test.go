@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/chatv1"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/k8schannel"
+)
+
+func TestNegotiateSubprotocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantName string
+		wantOK   bool
+	}{
+		{"no offer falls back to chat.v1", "", chatv1.Name, false},
+		{"single supported offer", "chat.v1", chatv1.Name, true},
+		{"k8s channel offer", "channel.k8s.io", k8schannel.Name, true},
+		{"picks first supported among several", "bogus, channel.k8s.io, chat.v1", k8schannel.Name, true},
+		{"no supported offer falls back to chat.v1", "bogus.v1", chatv1.Name, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, name, ok := negotiateSubprotocol(tt.header)
+			if name != tt.wantName || ok != tt.wantOK {
+				t.Fatalf("negotiateSubprotocol(%q) = (_, %q, %v), want (_, %q, %v)", tt.header, name, ok, tt.wantName, tt.wantOK)
+			}
+			if factory() == nil {
+				t.Errorf("factory() returned nil Protocol")
+			}
+		})
+	}
+}
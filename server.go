@@ -1,28 +1,134 @@
 package main
 
 import (
-	"bufio"
+	"compress/flate"
 	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
-	"encoding/binary"
-	"fmt"
-	"io"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"sync"
-)
+	"sync/atomic"
+	"time"
 
-const (
-	TextFrame  = 0x1
-	CloseFrame = 0x8
-	PingFrame  = 0x9
-	PongFrame  = 0xA
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
 )
 
+// outboundBufferSize is how many pending messages a Client's writer
+// goroutine will queue before enqueue starts reporting backpressure.
+const outboundBufferSize = 32
+
+// outboundHighWaterMark is how many consecutive times enqueue can find
+// the outbound buffer full before its caller should give up on the
+// client and let its connection be torn down.
+const outboundHighWaterMark = 8
+
+// outboundMessage is either a regular message frame or a close frame,
+// queued for the Client's single writer goroutine.
+type outboundMessage struct {
+	opcode      byte
+	payload     []byte
+	isClose     bool
+	closeCode   int
+	closeReason string
+}
+
 type Client struct {
 	Conn     net.Conn
+	WS       *wsproto.Conn
 	Username string
+	Protocol subprotocol.Protocol
+
+	// PeerCertificates holds the peer's verified chain, leaf first, when
+	// the connection was authenticated with a TLS client certificate.
+	PeerCertificates  []*x509.Certificate
+	certAuthenticated bool
+
+	// remoteIP is the key this client's connLimit slot was acquired
+	// under, released once the connection closes.
+	remoteIP string
+
+	// pingCounter and lastPong back the keepalive goroutine: pingCounter
+	// is a monotonically increasing ping payload, lastPong is the Unix
+	// nanosecond timestamp of the most recently received pong.
+	pingCounter uint64
+	lastPong    int64
+
+	outbound   chan outboundMessage
+	writeDone  chan struct{}
+	closeOnce  sync.Once
+	dropStreak int32
+}
+
+func newClient(conn net.Conn, proto subprotocol.Protocol) *Client {
+	return &Client{
+		Conn:      conn,
+		WS:        wsproto.NewConn(conn),
+		Protocol:  proto,
+		outbound:  make(chan outboundMessage, outboundBufferSize),
+		writeDone: make(chan struct{}),
+	}
+}
+
+// writeLoop is the sole writer of this client's connection: it drains
+// outbound and writes each message to the wire in order, so a broadcast
+// from another goroutine can never interleave with this client's own
+// close handshake. It exits once outbound is closed and drained, closing
+// the underlying connection on its way out.
+func (c *Client) writeLoop() {
+	defer close(c.writeDone)
+	defer c.Conn.Close()
+
+	for msg := range c.outbound {
+		var err error
+		if msg.isClose {
+			err = c.WS.WriteClose(msg.closeCode, msg.closeReason)
+		} else {
+			err = c.WS.WriteMessage(msg.opcode, msg.payload)
+		}
+		if err != nil {
+			log.Printf("Error writing to %s: %v\n", c.Username, err)
+			return
+		}
+		if msg.isClose {
+			return
+		}
+	}
+}
+
+// enqueue queues a message for delivery without blocking the caller. It
+// returns false once the outbound buffer has stayed saturated for more
+// than outboundHighWaterMark consecutive attempts, at which point the
+// caller should give up on this client.
+func (c *Client) enqueue(opcode byte, payload []byte) bool {
+	select {
+	case c.outbound <- outboundMessage{opcode: opcode, payload: payload}:
+		atomic.StoreInt32(&c.dropStreak, 0)
+		return true
+	default:
+		return atomic.AddInt32(&c.dropStreak, 1) <= outboundHighWaterMark
+	}
+}
+
+// enqueueClose queues a close frame. If the outbound buffer is saturated
+// it gives up on delivering the close frame and just tears the
+// connection down.
+func (c *Client) enqueueClose(code int, reason string) {
+	select {
+	case c.outbound <- outboundMessage{isClose: true, closeCode: code, closeReason: reason}:
+	default:
+		c.shutdownOutbound()
+	}
+}
+
+// shutdownOutbound closes outbound at most once, letting writeLoop drain
+// any queued messages and then close the connection.
+func (c *Client) shutdownOutbound() {
+	c.closeOnce.Do(func() { close(c.outbound) })
 }
 
 var (
@@ -30,10 +136,50 @@ var (
 	connMutex   = sync.Mutex{}
 )
 
-func main() {
+// Compression controls whether and how permessage-deflate (RFC 7692) is
+// offered during the handshake.
+var (
+	EnableCompression    = true
+	CompressionLevel     = flate.DefaultCompression
+	CompressionThreshold = 256
+)
+
+func init() {
 	http.HandleFunc("/ws", handleWebSocket)
-	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	certFile := flag.String("cert", "", "TLS certificate file (enables wss://)")
+	keyFile := flag.String("key", "", "TLS private key file")
+	clientCAFile := flag.String("client-ca", "", "PEM file of CAs trusted to sign client certificates")
+	requireClientCert := flag.Bool("require-client-cert", false, "require and verify a client certificate on every connection")
+	maxConns := flag.Int("max-conns", 0, "maximum concurrent connections server-wide (0 = unlimited)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "maximum concurrent connections per remote IP (0 = unlimited)")
+	pingInterval := flag.Duration("ping-interval", PingInterval, "how often to ping idle connections (0 disables keepalive)")
+	pongTimeout := flag.Duration("pong-timeout", PongTimeout, "how long to wait for a pong before closing a connection")
+	flag.Parse()
+
+	MaxConns = *maxConns
+	MaxConnsPerIP = *maxConnsPerIP
+	PingInterval = *pingInterval
+	PongTimeout = *pongTimeout
+
+	cfg := Config{Addr: *addr, CertFile: *certFile, KeyFile: *keyFile}
+	if *clientCAFile != "" {
+		pool, err := loadClientCAs(*clientCAFile)
+		if err != nil {
+			log.Fatalf("loading client CA file: %v", err)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if *requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	log.Println("Server started on", cfg.Addr)
+	log.Fatal(listenAndServe(cfg))
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -41,38 +187,90 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid WebSocket request", http.StatusBadRequest)
 		return
 	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	if !CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if ok, status := Authenticate.Authenticate(r); !ok {
+		http.Error(w, "Unauthorized", status)
+		return
+	}
+
+	ip := remoteIP(r)
+	if !connLimit.tryAcquire(ip, MaxConnsPerIP, MaxConns) {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
 
 	key := r.Header.Get("Sec-WebSocket-Key")
 	if key == "" {
+		connLimit.release(ip)
 		http.Error(w, "Missing WebSocket key", http.StatusBadRequest)
 		return
 	}
 	acceptKey := generateAcceptKey(key)
+	ext := negotiatePermessageDeflate(r.Header.Get("Sec-WebSocket-Extensions"))
+	protocolFactory, protocolName, echoProtocol := negotiateSubprotocol(r.Header.Get("Sec-WebSocket-Protocol"))
+
 	w.Header().Set("Upgrade", "websocket")
 	w.Header().Set("Connection", "Upgrade")
 	w.Header().Set("Sec-WebSocket-Accept", acceptKey)
+	if ext.enabled {
+		w.Header().Set("Sec-WebSocket-Extensions", ext.responseHeader())
+	}
+	if echoProtocol {
+		w.Header().Set("Sec-WebSocket-Protocol", protocolName)
+	}
 	w.WriteHeader(http.StatusSwitchingProtocols)
 
 	hj, ok := w.(http.Hijacker)
 	if !ok {
+		connLimit.release(ip)
 		http.Error(w, "HTTPS hijacking is not supported", http.StatusInternalServerError)
 		return
 	}
 
 	conn, _, err := hj.Hijack()
 	if err != nil {
+		connLimit.release(ip)
 		http.Error(w, "HTTP hijacking has failed", http.StatusInternalServerError)
 		return
 	}
 
 	log.Println("WebSocket connection has been established")
 
-	client := &Client{Conn: conn}
+	client := newClient(conn, protocolFactory())
+	client.remoteIP = ip
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		client.PeerCertificates = r.TLS.PeerCertificates
+		client.Username = peerCertIdentity(r.TLS.PeerCertificates[0])
+		client.certAuthenticated = true
+	}
+	if ext.enabled {
+		client.WS.EnableCompression(wsproto.CompressionConfig{
+			Level:                   CompressionLevel,
+			ClientNoContextTakeover: ext.clientNoContextTakeover,
+			ServerNoContextTakeover: ext.serverNoContextTakeover,
+			Threshold:               CompressionThreshold,
+		})
+	}
+
+	client.WS.SetTimeouts(PingInterval+PongTimeout, PongTimeout)
+	atomic.StoreInt64(&client.lastPong, time.Now().UnixNano())
 
 	connMutex.Lock()
 	connections[conn] = client
 	connMutex.Unlock()
 
+	go client.writeLoop()
+	go client.keepalive(PingInterval, PongTimeout)
+	client.Protocol.OnOpen(clientConn{client})
+
 	// Handle messages for this client
 	go handleFrames(client)
 }
@@ -86,145 +284,131 @@ func generateAcceptKey(key string) string {
 
 func handleFrames(client *Client) {
 	conn := client.Conn
+	peerClosed := false
 	defer func() {
 		connMutex.Lock()
 		delete(connections, conn)
 		connMutex.Unlock()
-		conn.Close()
+		if !peerClosed {
+			client.Protocol.OnClose(clientConn{client})
+		}
+		connLimit.release(client.remoteIP)
+		client.shutdownOutbound()
+		<-client.writeDone
 		log.Printf("Connection closed for %s\n", client.Username)
 	}()
 
-	usernameSet := false
-
 	for {
-		frame, err := readFrame(conn)
+		opcode, payload, err := client.WS.ReadMessage()
 		if err != nil {
-			log.Println("Error reading frame:", err)
-			break
-		}
-
-		switch frame.OpCode {
-		case TextFrame:
-			message := string(frame.Payload)
-			if !usernameSet {
-				// Set the username from the first message
-				client.Username = message
-				usernameSet = true
-				log.Printf("Username set for connection: %s\n", client.Username)
-				broadcastMessage(fmt.Sprintf("%s joined the chat", client.Username), "Server")
+			if protoErr, ok := err.(*wsproto.ProtocolError); ok {
+				log.Println("Protocol violation:", protoErr)
+				client.enqueueClose(protoErr.Code, protoErr.Msg)
 			} else {
-				log.Printf("[%s]: %s\n", client.Username, message)
-				broadcastMessage(message, client.Username)
+				log.Println("Error reading frame:", err)
 			}
-		case PingFrame:
+			return
+		}
+
+		switch opcode {
+		case wsproto.OpcodeText, wsproto.OpcodeBinary:
+			client.Protocol.OnMessage(clientConn{client}, opcode, payload)
+		case wsproto.OpcodePing:
 			log.Println("Received ping")
-			if err := writeFrame(conn, PongFrame, frame.Payload); err != nil {
-				log.Println("Error writing pong frame:", err)
-				break
-			}
-		case CloseFrame:
-			log.Printf("%s disconnected", client.Username)
-			broadcastMessage(fmt.Sprintf("%s left the chat", client.Username), "Server")
+			client.enqueue(wsproto.OpcodePong, payload)
+		case wsproto.OpcodePong:
+			atomic.StoreInt64(&client.lastPong, time.Now().UnixNano())
+		case wsproto.OpcodeClose:
+			peerClosed = true
+			completeCloseHandshake(client, payload)
 			return
 		default:
-			log.Printf("Unhandled frame type: %x\n", frame.OpCode)
+			log.Printf("Unhandled frame type: %x\n", opcode)
 		}
 	}
 }
 
-// broadcastMessage sends the message to all connected clients with a username prefix
-func broadcastMessage(message, username string) {
-	formattedMessage := fmt.Sprintf("[%s]: %s", username, message)
-
-	connMutex.Lock()
-	defer connMutex.Unlock()
-
-	for _, client := range connections {
-		if err := writeFrame(client.Conn, TextFrame, []byte(formattedMessage)); err != nil {
-			log.Printf("Error sending message to %s: %v\n", client.Username, err)
-			client.Conn.Close()
-			delete(connections, client.Conn)
+// completeCloseHandshake completes the RFC 6455 closing handshake: it parses
+// the peer's close payload and echoes back a matching (or corrected)
+// close frame before the caller tears down the TCP connection.
+func completeCloseHandshake(client *Client, payload []byte) {
+	code, _, err := wsproto.ParseClose(payload)
+	if err != nil {
+		if protoErr, ok := err.(*wsproto.ProtocolError); ok {
+			code = protoErr.Code
+		} else {
+			code = wsproto.CloseProtocolError
 		}
+	} else if code == wsproto.CloseNoStatusRcvd {
+		code = wsproto.CloseNormalClosure
 	}
-}
 
-type Frame struct {
-	OpCode  byte
-	Payload []byte
+	client.Protocol.OnClose(clientConn{client})
+	client.enqueueClose(code, "")
 }
 
-func readFrame(conn net.Conn) (Frame, error) {
-	reader := bufio.NewReader(conn)
-	frame := Frame{}
-
-	firstByte, err := reader.ReadByte()
-	if err != nil {
-		return frame, err
-	}
-	frame.OpCode = firstByte & 0x0F
-
-	secondByte, err := reader.ReadByte()
-	if err != nil {
-		return frame, err
-	}
-	payloadLen := int(secondByte & 0x7F)
+// broadcast sends payload as an opcode frame to every connected client
+// that negotiated protocolName, dropping any whose outbound buffer stays
+// saturated. Connections running a different subprotocol are untouched:
+// a chat.v1 broadcast must never land on a channel.k8s.io or chat.v2
+// wire, since those peers don't speak chat.v1's frame format.
+func broadcast(protocolName string, opcode byte, payload []byte) {
+	connMutex.Lock()
+	defer connMutex.Unlock()
 
-	if payloadLen == 126 {
-		var extendedLength uint16
-		if err := binary.Read(reader, binary.BigEndian, &extendedLength); err != nil {
-			return frame, err
+	for connKey, client := range connections {
+		if client.Protocol.Name() != protocolName {
+			continue
 		}
-		payloadLen = int(extendedLength)
-	} else if payloadLen == 127 {
-		var extendedLength uint64
-		if err := binary.Read(reader, binary.BigEndian, &extendedLength); err != nil {
-			return frame, err
+		if !client.enqueue(opcode, payload) {
+			log.Printf("Dropping slow client %s: outbound buffer saturated\n", client.Username)
+			delete(connections, connKey)
+			client.shutdownOutbound()
 		}
-		payloadLen = int(extendedLength)
 	}
+}
 
-	mask := secondByte&0x80 != 0
-	maskingKey := make([]byte, 4)
-	if mask {
-		if _, err := io.ReadFull(reader, maskingKey); err != nil {
-			return frame, err
-		}
-	}
+// dropSaturatedClient tears down a client whose outbound buffer has
+// stayed full past outboundHighWaterMark. It's the same drop path
+// broadcast applies inline under connMutex, factored out for callers
+// like clientConn.Send that enqueue a single message outside that loop.
+func dropSaturatedClient(client *Client) {
+	log.Printf("Dropping slow client %s: outbound buffer saturated\n", client.Username)
+	connMutex.Lock()
+	delete(connections, client.Conn)
+	connMutex.Unlock()
+	client.shutdownOutbound()
+}
 
-	frame.Payload = make([]byte, payloadLen)
-	if _, err := io.ReadFull(reader, frame.Payload); err != nil {
-		return frame, err
-	}
+// clientConn adapts a Client to subprotocol.Conn.
+type clientConn struct {
+	client *Client
+}
 
-	if mask {
-		for i := 0; i < payloadLen; i++ {
-			frame.Payload[i] ^= maskingKey[i%4]
-		}
+func (c clientConn) Send(opcode byte, payload []byte) error {
+	if !c.client.enqueue(opcode, payload) {
+		dropSaturatedClient(c.client)
 	}
-
-	return frame, nil
+	return nil
 }
 
-func writeFrame(conn net.Conn, opCode byte, payload []byte) error {
-	var buffer []byte
-
-	buffer = append(buffer, 0x80|opCode)
+func (c clientConn) Broadcast(opcode byte, payload []byte) {
+	broadcast(c.client.Protocol.Name(), opcode, payload)
+}
 
-	payloadLen := len(payload)
-	if payloadLen <= 125 {
-		buffer = append(buffer, byte(payloadLen))
-	} else if payloadLen <= 65535 {
-		buffer = append(buffer, 126)
-		buffer = append(buffer, byte(payloadLen>>8), byte(payloadLen&0xFF))
-	} else {
-		buffer = append(buffer, 127)
-		for i := 7; i >= 0; i-- {
-			buffer = append(buffer, byte(payloadLen>>(i*8)))
-		}
+func (c clientConn) SetUsername(name string) {
+	if c.client.certAuthenticated {
+		return
 	}
+	c.client.Username = name
+}
 
-	buffer = append(buffer, payload...)
+// PeerCertificates implements subprotocol.PeerCertificateProvider.
+func (c clientConn) PeerCertificates() []*x509.Certificate {
+	return c.client.PeerCertificates
+}
 
-	_, err := conn.Write(buffer)
-	return err
+// PresetUsername implements subprotocol.PresetUsername.
+func (c clientConn) PresetUsername() (string, bool) {
+	return c.client.Username, c.client.certAuthenticated
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MarcelOlsen/golang-chat-wss/internal/subprotocol"
+	"github.com/MarcelOlsen/golang-chat-wss/internal/wsproto"
+)
+
+// fakeProtocol is a minimal subprotocol.Protocol stand-in that only
+// carries a name, for tests that need clients negotiating distinct
+// protocols but don't exercise OnOpen/OnMessage/OnClose.
+type fakeProtocol struct{ name string }
+
+func (f fakeProtocol) Name() string                                           { return f.name }
+func (f fakeProtocol) OnOpen(conn subprotocol.Conn)                           {}
+func (f fakeProtocol) OnMessage(conn subprotocol.Conn, opcode byte, p []byte) {}
+func (f fakeProtocol) OnClose(conn subprotocol.Conn)                          {}
+
+func TestBroadcast_ScopedToSenderProtocol(t *testing.T) {
+	connMutex.Lock()
+	orig := connections
+	connections = make(map[net.Conn]*Client)
+	connMutex.Unlock()
+	t.Cleanup(func() {
+		connMutex.Lock()
+		connections = orig
+		connMutex.Unlock()
+	})
+
+	mkClient := func(protoName string) (*Client, net.Conn) {
+		server, peer := net.Pipe()
+		t.Cleanup(func() { peer.Close() })
+
+		c := newClient(server, fakeProtocol{name: protoName})
+		go c.writeLoop()
+		t.Cleanup(func() {
+			connMutex.Lock()
+			delete(connections, server)
+			connMutex.Unlock()
+			c.shutdownOutbound()
+			<-c.writeDone
+		})
+
+		connMutex.Lock()
+		connections[server] = c
+		connMutex.Unlock()
+
+		return c, peer
+	}
+
+	v1Client, v1Peer := mkClient("chat.v1")
+	_, k8sPeer := mkClient("channel.k8s.io")
+
+	broadcast(v1Client.Protocol.Name(), wsproto.OpcodeText, []byte("hello"))
+
+	buf := make([]byte, 64)
+	v1Peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := v1Peer.Read(buf)
+	if err != nil {
+		t.Fatalf("chat.v1 peer Read() error = %v", err)
+	}
+	if n == 0 {
+		t.Error("chat.v1 peer received no bytes, want the broadcast frame")
+	}
+
+	k8sPeer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := k8sPeer.Read(buf); err == nil {
+		t.Error("channel.k8s.io peer received a chat.v1 broadcast, want no frame")
+	}
+}
+
+// TestClientConn_Send_DropsClientWhenOutboundStaysSaturated guards
+// against Send silently swallowing backpressure: a client reachable only
+// through direct Send calls (e.g. k8schannel's stdout echo, chatv2's
+// error/list replies) must be torn down once its outbound buffer stays
+// full past outboundHighWaterMark, the same as a client fed via
+// broadcast.
+func TestClientConn_Send_DropsClientWhenOutboundStaysSaturated(t *testing.T) {
+	connMutex.Lock()
+	orig := connections
+	connections = make(map[net.Conn]*Client)
+	connMutex.Unlock()
+	t.Cleanup(func() {
+		connMutex.Lock()
+		connections = orig
+		connMutex.Unlock()
+	})
+
+	server, peer := net.Pipe()
+	defer peer.Close()
+
+	// No writeLoop is started, so nothing ever drains outbound and it
+	// fills up deterministically.
+	c := newClient(server, fakeProtocol{name: "chat.v1"})
+	connMutex.Lock()
+	connections[server] = c
+	connMutex.Unlock()
+
+	conn := clientConn{client: c}
+	for i := 0; i < outboundBufferSize+outboundHighWaterMark+1; i++ {
+		conn.Send(wsproto.OpcodeText, []byte("x"))
+	}
+
+	connMutex.Lock()
+	_, stillRegistered := connections[server]
+	connMutex.Unlock()
+	if stillRegistered {
+		t.Error("client still registered after its outbound buffer stayed saturated past the high-water mark")
+	}
+}
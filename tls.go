@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// Config describes how to serve the WebSocket endpoint. Leaving CertFile
+// and KeyFile empty serves plain ws:// via ListenAndServe; setting both
+// serves wss:// via ListenAndServeTLS instead.
+type Config struct {
+	Addr       string
+	CertFile   string
+	KeyFile    string
+	ClientCAs  *x509.CertPool
+	ClientAuth tls.ClientAuthType
+	MinVersion uint16
+	NextProtos []string
+}
+
+// newServer builds an *http.Server for cfg, using the DefaultServeMux.
+// WebSockets don't yet implement the RFC 8441 extended CONNECT method
+// needed to run over HTTP/2, so unless cfg.NextProtos says otherwise the
+// TLS handshake is restricted to HTTP/1.1.
+func newServer(cfg Config) *http.Server {
+	srv := &http.Server{Addr: cfg.Addr}
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		return srv
+	}
+
+	nextProtos := cfg.NextProtos
+	if nextProtos == nil {
+		nextProtos = []string{"http/1.1"}
+	}
+	srv.TLSConfig = &tls.Config{
+		ClientAuth: cfg.ClientAuth,
+		ClientCAs:  cfg.ClientCAs,
+		MinVersion: cfg.MinVersion,
+		NextProtos: nextProtos,
+	}
+	if !slices.Contains(nextProtos, "h2") {
+		// A non-nil TLSNextProto without an "h2" entry stops net/http
+		// from adding and serving h2 on its own.
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return srv
+}
+
+// listenAndServe starts srv in plain or TLS mode, depending on whether
+// cfg carries a certificate.
+func listenAndServe(cfg Config) error {
+	srv := newServer(cfg)
+	if srv.TLSConfig == nil {
+		return srv.ListenAndServe()
+	}
+	return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
+
+// loadClientCAs reads a PEM file of CA certificates trusted to sign
+// client certificates.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// peerCertIdentity derives a display identity from a client certificate:
+// its Subject Common Name, falling back to its first DNS SAN.
+func peerCertIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.String()
+}
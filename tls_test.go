@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generateCert issues a certificate for commonName, self-signed when
+// parent/parentKey are nil, otherwise signed by parent.
+func generateCert(t *testing.T, parent *x509.Certificate, parentKey *rsa.PrivateKey, commonName string, isCA bool) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("generating serial number: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent, parentKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("creating certificate for %s: %v", commonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate for %s: %v", commonName, err)
+	}
+	return cert, key
+}
+
+// writePEMFile PEM-encodes der under blockType and writes it to name
+// inside dir, returning the full path.
+func writePEMFile(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+	return path
+}
+
+// TestListenAndServeTLS_ClientCertAuthentication dials a server started
+// via listenAndServe with a self-signed CA and a client certificate, and
+// checks the accepted Client's Username comes from the certificate's CN.
+func TestListenAndServeTLS_ClientCertAuthentication(t *testing.T) {
+	ca, caKey := generateCert(t, nil, nil, "test-ca", true)
+	serverCert, serverKey := generateCert(t, ca, caKey, "127.0.0.1", false)
+	clientCert, clientKey := generateCert(t, ca, caKey, "alice", false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	dir := t.TempDir()
+	certPath := writePEMFile(t, dir, "server.crt", "CERTIFICATE", serverCert.Raw)
+	keyPath := writePEMFile(t, dir, "server.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(serverKey))
+
+	srv := newServer(Config{
+		Addr:       "127.0.0.1:0",
+		CertFile:   certPath,
+		KeyFile:    keyPath,
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go srv.ServeTLS(ln, certPath, keyPath)
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		RootCAs: pool,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{clientCert.Raw},
+			PrivateKey:  clientKey,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	req := "GET /ws HTTP/1.1\r\n" +
+		"Host: 127.0.0.1\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + base64.StdEncoding.EncodeToString([]byte("0123456789012345")) + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("writing upgrade request: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	client := waitForClient(t, conn.LocalAddr())
+	if client.Username != "alice" {
+		t.Errorf("Username = %q, want %q", client.Username, "alice")
+	}
+	if len(client.PeerCertificates) != 1 || client.PeerCertificates[0].Subject.CommonName != "alice" {
+		t.Errorf("PeerCertificates = %v, want a single cert for alice", client.PeerCertificates)
+	}
+
+	// chat.v1's join announcement, broadcast from OnOpen, must reflect
+	// the certificate identity rather than waiting on a first text frame
+	// that never comes. Keep reading through br: any bytes the server
+	// already sent may have landed in its buffer alongside the upgrade
+	// response.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	n, err := br.Read(buf)
+	if err != nil {
+		t.Fatalf("reading join announcement: %v", err)
+	}
+	if got := buf[:n]; !bytes.Contains(got, []byte("alice joined the chat")) {
+		t.Errorf("join announcement = %q, want it to mention alice", got)
+	}
+}
+
+// waitForClient polls the server's connections map for the Client whose
+// remote address matches localAddr (the dialer's local address, i.e. the
+// server's peer), since registration happens asynchronously after Hijack.
+func waitForClient(t *testing.T, localAddr net.Addr) *Client {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		connMutex.Lock()
+		for conn, client := range connections {
+			if conn.RemoteAddr().String() == localAddr.String() {
+				connMutex.Unlock()
+				return client
+			}
+		}
+		connMutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server never registered a client for this connection")
+	return nil
+}